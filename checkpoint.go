@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/lucky-abc/cleat/filelog"
+	"github.com/lucky-abc/cleat/record"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const checkpointExportVersion = 1
+
+const checkpointUsage = `Usage: cleat checkpoint <action> [arguments] [flags]
+
+Actions:
+  list [prefix]          print the stored keys and values
+  get <file|key>         print the offset of a file path or a key
+  set <file|key> <offset>
+                         change the offset, a file path without checkpoint gets
+                         a path keyed offset applied when the file is opened
+  reset <file|key>...    delete the checkpoints, the files are read from the beginning
+  reset --all            delete every checkpoint
+  export [output]        write the checkpoints as json, to stdout by default
+  import <input>         read checkpoints exported on this or another host,
+                         "-" reads stdin
+
+Keys look like filelog-id-<file identity> and window-event-[<channel>],
+filelog-path-<path> and dirlog-path-<path> map the files of the file and
+directory paths to their identity. Export keeps the file offsets by path, so
+they apply to the same paths on a new host.
+
+`
+
+var checkpointAll bool
+
+func setCheckpointFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&checkpointAll, "all", false, "reset every checkpoint")
+}
+
+// checkpointExport is the json written by checkpoint export, Files are the
+// file offsets by path and Entries the other keys, e.g. windows channels.
+type checkpointExport struct {
+	Version  int                      `json:"version"`
+	Host     string                   `json:"host"`
+	Exported time.Time                `json:"exported"`
+	Files    []filelog.FileCheckpoint `json:"files"`
+	Entries  []record.Entry           `json:"entries"`
+}
+
+func runCommandCheckpoint(paths startupPaths, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, checkpointUsage)
+		return 2
+	}
+	action, args := args[0], args[1:]
+	var run func(ck *record.RecordPoint, args []string) error
+	switch action {
+	case "list":
+		run = checkpointList
+	case "get":
+		run = checkpointGet
+	case "set":
+		run = checkpointSet
+	case "reset":
+		run = checkpointReset
+	case "export":
+		run = checkpointExportTo
+	case "import":
+		run = checkpointImport
+	default:
+		fmt.Fprintf(os.Stderr, "unknown checkpoint action: %s\n\n", action)
+		fmt.Fprint(os.Stderr, checkpointUsage)
+		return 2
+	}
+	ck, err := openCheckpoint(paths, action == "import")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer ck.Close()
+	if err := run(ck, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// openCheckpoint opens the store of a stopped cleat, the store is locked
+// while cleat runs. Only create makes a new store.
+func openCheckpoint(paths startupPaths, create bool) (*record.RecordPoint, error) {
+	dbpath := filepath.Join(paths.dataDir, checkpointDir)
+	if _, err := os.Stat(dbpath); err != nil && !create {
+		return nil, fmt.Errorf("no checkpoint store in %s: %v", paths.dataDir, err)
+	}
+	ck, err := record.NewCheckpoint(dbpath)
+	if err != nil {
+		return nil, fmt.Errorf("open checkpoint store error, is cleat running? %v", err)
+	}
+	return ck, nil
+}
+
+func checkpointList(ck *record.RecordPoint, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: cleat checkpoint list [prefix]")
+	}
+	entries, err := ck.Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if len(args) == 0 || strings.HasPrefix(e.Key, args[0]) {
+			fmt.Printf("%s\t%s\n", e.Key, e.Value)
+		}
+	}
+	return nil
+}
+
+// lookupCheckpoint resolves a stored key first and then a file path.
+func lookupCheckpoint(ck *record.RecordPoint, target string) (key string, value string, fc *filelog.FileCheckpoint, err error) {
+	value, err = ck.GetValue(target)
+	if err != nil || value != "" {
+		return target, value, nil, err
+	}
+	c, ok, err := filelog.FindFileCheckpoint(ck, target)
+	if err != nil || !ok {
+		return "", "", nil, err
+	}
+	return c.Key, strconv.FormatUint(c.Offset, 10), &c, nil
+}
+
+func checkpointGet(ck *record.RecordPoint, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: cleat checkpoint get <file|key>")
+	}
+	key, value, _, err := lookupCheckpoint(ck, args[0])
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("no checkpoint of %s", args[0])
+	}
+	fmt.Printf("%s\t%s\n", key, value)
+	return nil
+}
+
+func checkpointSet(ck *record.RecordPoint, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: cleat checkpoint set <file|key> <offset>")
+	}
+	target := args[0]
+	offset, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid offset: %s", args[1])
+	}
+	key, _, fc, err := lookupCheckpoint(ck, target)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fc != nil:
+		key, err = filelog.SetFileCheckpoint(ck, fc.Path, fc.Type, offset)
+	case key != "" && filelog.IsMetadataKey(key):
+		return fmt.Errorf("%s is not an offset", key)
+	case key != "":
+		ck.SetCheckpoint(key, offset)
+	case filelog.IsFileCheckpointKey(target) || strings.HasPrefix(target, "window-event-"):
+		key = target
+		ck.SetCheckpoint(key, offset)
+	default:
+		key, err = filelog.SetFileCheckpoint(ck, target, filelog.FileCheckpointFile, offset)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\t%d\n", key, offset)
+	return nil
+}
+
+func checkpointReset(ck *record.RecordPoint, args []string) error {
+	if checkpointAll == (len(args) > 0) {
+		return fmt.Errorf("usage: cleat checkpoint reset <file|key>... | --all")
+	}
+	if checkpointAll {
+		entries, err := ck.Entries()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			ck.DelCheckpoint(e.Key)
+		}
+		fmt.Printf("%d keys deleted\n", len(entries))
+		return nil
+	}
+	for _, target := range args {
+		value, err := ck.GetValue(target)
+		if err != nil {
+			return err
+		}
+		deleted := []string{target}
+		if value != "" {
+			ck.DelCheckpoint(target)
+		} else if deleted, err = filelog.ResetFileCheckpoint(ck, target); err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return fmt.Errorf("no checkpoint of %s", target)
+		}
+		for _, key := range deleted {
+			fmt.Printf("%s\tdeleted\n", key)
+		}
+	}
+	return nil
+}
+
+func checkpointExportTo(ck *record.RecordPoint, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: cleat checkpoint export [output]")
+	}
+	files, err := filelog.FileCheckpoints(ck)
+	if err != nil {
+		return err
+	}
+	entries, err := ck.Entries()
+	if err != nil {
+		return err
+	}
+	export := checkpointExport{
+		Version:  checkpointExportVersion,
+		Exported: time.Now(),
+		Files:    files,
+		Entries:  make([]record.Entry, 0),
+	}
+	export.Host, _ = os.Hostname()
+	for _, e := range entries {
+		//文件的读取位置按路径导出，文件标识在其他主机上无效
+		if !filelog.IsFileCheckpointKey(e.Key) {
+			export.Entries = append(export.Entries, e)
+		}
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if len(args) == 0 || args[0] == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(args[0], data, 0644)
+}
+
+func checkpointImport(ck *record.RecordPoint, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: cleat checkpoint import <input>")
+	}
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	var export checkpointExport
+	if err := json.NewDecoder(in).Decode(&export); err != nil {
+		return fmt.Errorf("parse %s error: %v", args[0], err)
+	}
+	if export.Version != checkpointExportVersion {
+		return fmt.Errorf("unsupported checkpoint export version: %d", export.Version)
+	}
+	for i, fc := range export.Files {
+		if fc.Path == "" || (fc.Type != filelog.FileCheckpointFile && fc.Type != filelog.FileCheckpointDir) {
+			return fmt.Errorf("files[%d]: invalid path or type", i)
+		}
+	}
+	for _, fc := range export.Files {
+		if _, err := filelog.SetFileCheckpoint(ck, fc.Path, fc.Type, fc.Offset); err != nil {
+			return err
+		}
+	}
+	for _, e := range export.Entries {
+		ck.SetValue(e.Key, e.Value)
+	}
+	fmt.Printf("%d file checkpoints and %d keys imported from %s\n", len(export.Files), len(export.Entries), export.Host)
+	return nil
+}
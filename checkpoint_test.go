@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/lucky-abc/cleat/filelog"
+	"github.com/lucky-abc/cleat/record"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestStore(t *testing.T, dir string) *record.RecordPoint {
+	t.Helper()
+	ck, err := openCheckpoint(startupPaths{dataDir: dir}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ck
+}
+
+func checkValue(t *testing.T, ck *record.RecordPoint, key string, want string) {
+	t.Helper()
+	if got, err := ck.GetValue(key); err != nil || got != want {
+		t.Errorf("%s = %q %v, want %q", key, got, err, want)
+	}
+}
+
+func TestCheckpointSetAndReset(t *testing.T) {
+	ck := newTestStore(t, newTestDataDir(t))
+	defer ck.Close()
+	ck.SetValue("filelog-path-/var/log/app.log", "1-2")
+	ck.SetCheckpoint("filelog-id-1-2", 100)
+	ck.SetValue("filelog-done-1-2", "fp")
+
+	//已读完的文件设置位置后从新的位置继续读取
+	if err := checkpointSet(ck, []string{"/var/log/app.log", "10"}); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, ck, "filelog-id-1-2", "10")
+	checkValue(t, ck, "filelog-done-1-2", "")
+	if err := checkpointSet(ck, []string{"window-event-[Application]", "42"}); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, ck, "window-event-[Application]", "42")
+	if err := checkpointSet(ck, []string{"/var/log/new.log", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, ck, "filelog-/var/log/new.log", "5")
+	for _, args := range [][]string{{"filelog-path-/var/log/app.log", "1"}, {"/var/log/app.log", "-1"}, {"/var/log/app.log"}} {
+		if err := checkpointSet(ck, args); err == nil {
+			t.Errorf("set %q accepted", args)
+		}
+	}
+
+	if err := checkpointReset(ck, []string{"/var/log/app.log", "window-event-[Application]"}); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, ck, "filelog-path-/var/log/app.log", "")
+	checkValue(t, ck, "filelog-id-1-2", "")
+	checkValue(t, ck, "window-event-[Application]", "")
+	if err := checkpointReset(ck, []string{"/var/log/app.log"}); err == nil {
+		t.Error("reset of a path without checkpoint succeeded")
+	}
+	checkpointAll = true
+	defer func() { checkpointAll = false }()
+	if err := checkpointReset(ck, nil); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ck.Entries(); len(entries) != 0 {
+		t.Errorf("entries left after reset --all: %v", entries)
+	}
+}
+
+func TestCheckpointExportImport(t *testing.T) {
+	dir := newTestDataDir(t)
+	ck := newTestStore(t, filepath.Join(dir, "old"))
+	ck.SetValue("filelog-path-/var/log/app.log", "1-2")
+	ck.SetCheckpoint("filelog-id-1-2", 100)
+	ck.SetValue("filelog-fp-1-2", "fp")
+	ck.SetValue("dirlog-path-/var/log/nginx/access.log", "3-4")
+	ck.SetCheckpoint("filelog-id-3-4", 200)
+	ck.SetCheckpoint("window-event-[Application]", 42)
+	exported := filepath.Join(dir, "checkpoints.json")
+	err := checkpointExportTo(ck, []string{exported})
+	ck.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//新主机上的文件标识不同，按路径导入，读取器打开文件时换成文件标识
+	ck = newTestStore(t, filepath.Join(dir, "new"))
+	defer ck.Close()
+	if err := checkpointImport(ck, []string{exported}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := filelog.FileCheckpoints(ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		got[i].Key = ""
+	}
+	want := []filelog.FileCheckpoint{
+		{Path: "/var/log/nginx/access.log", Type: filelog.FileCheckpointDir, Offset: 200},
+		{Path: "/var/log/app.log", Type: filelog.FileCheckpointFile, Offset: 100},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported %+v, want %+v", got, want)
+	}
+	checkValue(t, ck, "window-event-[Application]", "42")
+	checkValue(t, ck, "filelog-id-1-2", "")
+	checkValue(t, ck, "filelog-fp-1-2", "")
+}
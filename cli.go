@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/lucky-abc/cleat/config"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	envConfig  = "CLEAT_CONFIG"
+	envDataDir = "CLEAT_DATA_DIR"
+	envLogDir  = "CLEAT_LOG_DIR"
+
+	checkpointDir = "recordpoint"
+)
+
+// startupPaths are set by the flags, then the environment, and default to the
+// config, data and logs directories next to the bin directory of the executable.
+type startupPaths struct {
+	configFile string
+	dataDir    string
+	logDir     string
+}
+
+type command struct {
+	name        string
+	description string
+	usage       string
+	setFlags    func(flags *flag.FlagSet)
+	run         func(paths startupPaths, args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "run", description: "collect and forward the logs, the default command", run: runCommandRun},
+		{name: "validate", description: "check the config file and exit", run: runCommandValidate},
+		{name: "version", description: "print the version", run: runCommandVersion},
+		{name: "checkpoint", description: "inspect and change the read positions, cleat must be stopped",
+			usage: checkpointUsage, setFlags: setCheckpointFlags, run: runCommandCheckpoint},
+	}
+}
+
+func runCommand(args []string) int {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout, nil)
+		return 0
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", name)
+		printUsage(os.Stderr, nil)
+		return 2
+	}
+	flags, paths := newFlagSet(cmd.name)
+	if cmd.setFlags != nil {
+		cmd.setFlags(flags)
+	}
+	flags.Usage = func() {
+		if cmd.usage != "" {
+			fmt.Fprint(flags.Output(), cmd.usage)
+			fmt.Fprintln(flags.Output(), "Flags:")
+			flags.PrintDefaults()
+			return
+		}
+		printUsage(flags.Output(), flags)
+	}
+	positional, err := parseFlags(flags, args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	return cmd.run(*paths, positional)
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *startupPaths) {
+	_, configPath, dataPath, logPath := getStartupPath()
+	paths := &startupPaths{}
+	flags := flag.NewFlagSet("cleat "+name, flag.ContinueOnError)
+	flags.StringVar(&paths.configFile, "config", envOrDefault(envConfig, filepath.Join(configPath, "config.yaml")),
+		"config file, env "+envConfig)
+	flags.StringVar(&paths.dataDir, "data-dir", envOrDefault(envDataDir, dataPath),
+		"directory of the checkpoints and the spool, env "+envDataDir)
+	flags.StringVar(&paths.logDir, "log-dir", envOrDefault(envLogDir, logPath),
+		"directory of the log file, env "+envLogDir)
+	return flags, paths
+}
+
+func envOrDefault(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// parseFlags accepts the flags before and after the arguments, like
+// "cleat checkpoint list --data-dir /var/lib/cleat".
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: cleat [command] [arguments] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-12s%s\n", c.name, c.description)
+	}
+	if flags == nil {
+		flags, _ = newFlagSet("")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags:")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+}
+
+func runCommandRun(paths startupPaths, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(args, " "))
+		return 2
+	}
+	return run(paths)
+}
+
+func runCommandValidate(paths startupPaths, args []string) int {
+	if err := config.InitSystemConfig(paths.configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, _, err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "config file error:\n%v\n", err)
+		return 1
+	}
+	fmt.Printf("config file ok: %s\n", paths.configFile)
+	return 0
+}
+
+func runCommandVersion(paths startupPaths, args []string) int {
+	fmt.Printf("cleat %s %s/%s %s\n", version, runtime.GOOS, runtime.GOARCH, runtime.Version())
+	return 0
+}
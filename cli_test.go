@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func newTestDataDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cleat-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestParseFlagsAfterArguments(t *testing.T) {
+	defer func() { checkpointAll = false }()
+	flags, paths := newFlagSet("checkpoint")
+	setCheckpointFlags(flags)
+	args, err := parseFlags(flags, []string{"reset", "--data-dir", "/var/lib/cleat", "app.log", "--all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{"reset", "app.log"}) || paths.dataDir != "/var/lib/cleat" || !checkpointAll {
+		t.Fatalf("args %q data dir %q all %v", args, paths.dataDir, checkpointAll)
+	}
+	if _, err := parseFlags(flags, []string{"--nope"}); err == nil {
+		t.Error("unknown flag accepted")
+	}
+}
+
+func TestStartupPathsFromEnvironment(t *testing.T) {
+	defer os.Unsetenv(envDataDir)
+	defer os.Unsetenv(envConfig)
+	os.Setenv(envDataDir, "/env/data")
+	os.Setenv(envConfig, "/env/config.yaml")
+	flags, paths := newFlagSet("run")
+	if _, err := parseFlags(flags, []string{"--config", "/flag/config.yaml"}); err != nil {
+		t.Fatal(err)
+	}
+	//命令行参数优先于环境变量
+	if paths.configFile != "/flag/config.yaml" || paths.dataDir != "/env/data" {
+		t.Fatalf("config %q data dir %q", paths.configFile, paths.dataDir)
+	}
+}
+
+func TestRunCommandExitCodes(t *testing.T) {
+	empty := newTestDataDir(t)
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"help"}, 0},
+		{[]string{"version"}, 0},
+		{[]string{"version", "-h"}, 0},
+		{[]string{"nope"}, 2},
+		{[]string{"--nope"}, 2},
+		{[]string{"run", "extra"}, 2},
+		{[]string{"checkpoint"}, 2},
+		{[]string{"checkpoint", "nope", "--data-dir", empty}, 2},
+		{[]string{"checkpoint", "list", "--data-dir", empty}, 1},
+		{[]string{"validate", "--config", empty + "/missing.yaml"}, 1},
+	}
+	for _, tt := range tests {
+		if code := runCommand(tt.args); code != tt.code {
+			t.Errorf("cleat %q exited with %d, want %d", tt.args, code, tt.code)
+		}
+	}
+}
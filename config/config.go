@@ -2,23 +2,92 @@ package config
 
 import (
 	"fmt"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"strings"
+	"sync"
+	"time"
 )
 
-var config *viper.Viper
+var (
+	config      *viper.Viper
+	configMutex sync.RWMutex
+	configFile  string
+)
+
+// watchDelay merges the burst of events an editor produces when saving.
+const watchDelay = time.Second
+
+// InitSystemConfig reads file, which is yaml whatever its extension.
+func InitSystemConfig(file string) error {
+	configFile = file
+	v, err := readConfig()
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config = v
+	return err
+}
+
+func readConfig() (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read config file %s error: %v", configFile, err)
+	}
+	return v, nil
+}
 
-func InitSystemConfig(file string, configPath string) {
-	config = viper.New()
-	config.SetConfigName(file)
-	config.SetConfigType("yaml")
+// Reload reads the config file again, the current config is kept when it fails.
+func Reload() error {
+	v, err := readConfig()
+	if err != nil {
+		return err
+	}
+	//替换配置时可能有其他goroutine正在读取
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config = v
+	return nil
+}
 
-	config.AddConfigPath(configPath)
-	err := config.ReadInConfig()
+// Watch calls onChange after the config file is modified, the file is
+// watched by a viper instance of its own so Config() is only replaced by Reload.
+func Watch(onChange func()) error {
+	v, err := readConfig()
 	if err != nil {
-		panic(fmt.Errorf("Fatal error config file: %s \n", err))
+		return err
 	}
+	var mutex sync.Mutex
+	var timer *time.Timer
+	v.OnConfigChange(func(e fsnotify.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(watchDelay, onChange)
+	})
+	v.WatchConfig()
+	return nil
 }
 
 func Config() *viper.Viper {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
 	return config
 }
+
+// MapValue looks key up case-insensitively, yaml maps nested in lists keep
+// the case of the config file while viper lowercases everything else.
+func MapValue(m map[string]interface{}, key string) interface{} {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
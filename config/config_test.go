@@ -0,0 +1,50 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestReloadWhileLoading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleat-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(file, []byte("log:\n  logLevel: WARN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitSystemConfig(file); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := Reload(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			sc, err := Load()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if sc.Log.LogLevel != "WARN" {
+				t.Errorf("log level %s", sc.Log.LogLevel)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
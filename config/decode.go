@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"strings"
+)
+
+type keyError struct {
+	path    string
+	message string
+}
+
+// Errors collects the problems found in the config, each one with the yaml
+// key path it was found at, so all of them can be reported at once.
+type Errors struct {
+	errs []keyError
+}
+
+func (e *Errors) Add(path string, format string, args ...interface{}) {
+	e.errs = append(e.errs, keyError{path: path, message: fmt.Sprintf(format, args...)})
+}
+
+// Merge adds err found under path, the paths of a nested Errors are relative to it.
+func (e *Errors) Merge(path string, err error) {
+	if err == nil {
+		return
+	}
+	nested, ok := err.(*Errors)
+	if !ok {
+		e.Add(path, "%v", err)
+		return
+	}
+	for _, ke := range nested.errs {
+		e.errs = append(e.errs, keyError{path: JoinPath(path, ke.path), message: ke.message})
+	}
+}
+
+func (e *Errors) Len() int {
+	return len(e.errs)
+}
+
+// Err returns nil when nothing was added.
+func (e *Errors) Err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *Errors) Error() string {
+	lines := make([]string, 0, len(e.errs))
+	for _, ke := range e.errs {
+		if ke.path == "" {
+			lines = append(lines, ke.message)
+			continue
+		}
+		lines = append(lines, ke.path+": "+ke.message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func JoinPath(parent string, key string) string {
+	switch {
+	case parent == "":
+		return key
+	case key == "":
+		return parent
+	case strings.HasPrefix(key, "["):
+		return parent + key
+	}
+	return parent + "." + key
+}
+
+// Decode copies a config section into out. Keys match the field names case
+// insensitively, strings are converted to numbers, bools and durations, and
+// unknown keys are reported as errors. Fields missing from input keep the
+// value out already has, which is how defaults are set.
+func Decode(input interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	err = decoder.Decode(input)
+	if err == nil {
+		return nil
+	}
+	decodeErr, ok := err.(*mapstructure.Error)
+	if !ok {
+		return err
+	}
+	errs := &Errors{}
+	for _, message := range decodeErr.Errors {
+		//mapstructure的错误信息中用引号标出键的路径
+		path := ""
+		if start := strings.Index(message, "'"); start >= 0 {
+			if end := strings.Index(message[start+1:], "'"); end >= 0 {
+				path = message[start+1 : start+1+end]
+				message = strings.Join(strings.Fields(message[:start]+message[start+end+2:]), " ")
+				message = strings.TrimPrefix(message, "error decoding : ")
+			}
+		}
+		if strings.HasPrefix(message, "has invalid keys: ") {
+			for _, key := range strings.Split(strings.TrimPrefix(message, "has invalid keys: "), ", ") {
+				errs.Add(JoinPath(path, key), "unknown key")
+			}
+			continue
+		}
+		errs.Add(path, "%s", message)
+	}
+	return errs
+}
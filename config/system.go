@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLogFile              = "cleat.log"
+	defaultMetricReportInterval = 10 * time.Second
+	defaultMetricReportLevel    = "INFO"
+)
+
+var logLevels = []string{"debug", "info", "warn", "error", "panic", "fatal"}
+
+// SystemConfig is the whole config file. The tunnels and the legacy windows,
+// files, output, processors and spool sections are kept raw here, they are
+// decoded by the tunnel package with the parsers of their plugins.
+type SystemConfig struct {
+	Host        string        `mapstructure:"host"`
+	WatchConfig bool          `mapstructure:"watchConfig"`
+	Log         LogConfig     `mapstructure:"log"`
+	Metrics     MetricsConfig `mapstructure:"metrics"`
+	Tunnels     interface{}   `mapstructure:"tunnels"`
+	Windows     interface{}   `mapstructure:"windows"`
+	Files       interface{}   `mapstructure:"files"`
+	Output      interface{}   `mapstructure:"output"`
+	Processors  interface{}   `mapstructure:"processors"`
+	Spool       interface{}   `mapstructure:"spool"`
+}
+
+type LogConfig struct {
+	LogLevel string        `mapstructure:"logLevel"`
+	LogFile  LogFileConfig `mapstructure:"logFile"`
+}
+
+// LogFileConfig sets the rotation of the log file, MaxSize is in megabytes
+// and MaxAge in days.
+type LogFileConfig struct {
+	Filename   string `mapstructure:"Filename"`
+	MaxSize    int    `mapstructure:"MaxSize"`
+	MaxBackups int    `mapstructure:"MaxBackups"`
+	MaxAge     int    `mapstructure:"MaxAge"`
+	Compress   bool   `mapstructure:"Compress"`
+}
+
+type MetricsConfig struct {
+	Reporters []ReporterConfig `mapstructure:"reporters"`
+}
+
+// ReporterConfig is one entry of metrics.reporters, which declares one of
+// the reporters.
+type ReporterConfig struct {
+	Logfile    *LogfileReporterConfig    `mapstructure:"logfile"`
+	Prometheus *PrometheusReporterConfig `mapstructure:"prometheus"`
+}
+
+type LogfileReporterConfig struct {
+	Level          string        `mapstructure:"level"`
+	ReportInterval time.Duration `mapstructure:"reportInterval"`
+}
+
+type PrometheusReporterConfig struct {
+	Listen string `mapstructure:"listen"`
+	Path   string `mapstructure:"path"`
+}
+
+// Load decodes and checks the current config file. The returned config is
+// filled as far as possible even when the error, a *Errors, lists problems,
+// so the caller can go on checking the sections it handles.
+func Load() (*SystemConfig, error) {
+	sc := &SystemConfig{
+		Log: LogConfig{
+			LogLevel: "INFO",
+			LogFile:  LogFileConfig{Filename: defaultLogFile},
+		},
+	}
+	if err := Decode(Config().AllSettings(), sc); err != nil {
+		return sc, err
+	}
+	errs := &Errors{}
+	sc.check(errs)
+	return sc, errs.Err()
+}
+
+func (sc *SystemConfig) check(errs *Errors) {
+	if !validLogLevel(sc.Log.LogLevel) {
+		errs.Add("log.logLevel", "unknown level: %s", sc.Log.LogLevel)
+	}
+	if strings.TrimSpace(sc.Log.LogFile.Filename) == "" {
+		errs.Add("log.logFile.Filename", "must not be empty")
+	}
+	for _, v := range []struct {
+		key   string
+		value int
+	}{{"MaxSize", sc.Log.LogFile.MaxSize}, {"MaxBackups", sc.Log.LogFile.MaxBackups}, {"MaxAge", sc.Log.LogFile.MaxAge}} {
+		if v.value < 0 {
+			errs.Add("log.logFile."+v.key, "must not be negative")
+		}
+	}
+	for i, rc := range sc.Metrics.Reporters {
+		path := fmt.Sprintf("metrics.reporters[%d]", i)
+		if rc.Logfile == nil && rc.Prometheus == nil {
+			errs.Add(path, "must declare a logfile or prometheus reporter")
+		}
+		if rc.Logfile != nil {
+			switch {
+			case rc.Logfile.Level == "":
+				rc.Logfile.Level = defaultMetricReportLevel
+			case !validLogLevel(rc.Logfile.Level):
+				errs.Add(path+".logfile.level", "unknown level: %s", rc.Logfile.Level)
+			}
+			switch {
+			case rc.Logfile.ReportInterval == 0:
+				rc.Logfile.ReportInterval = defaultMetricReportInterval
+			case rc.Logfile.ReportInterval < 0:
+				errs.Add(path+".logfile.reportInterval", "must be positive")
+			}
+		}
+		if rc.Prometheus != nil {
+			if _, _, err := net.SplitHostPort(rc.Prometheus.Listen); err != nil {
+				errs.Add(path+".prometheus.listen", "%v", err)
+			}
+			if rc.Prometheus.Path != "" && !strings.HasPrefix(rc.Prometheus.Path, "/") {
+				errs.Add(path+".prometheus.path", "must start with /")
+			}
+		}
+	}
+}
+
+func validLogLevel(level string) bool {
+	for _, l := range logLevels {
+		if strings.EqualFold(l, level) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckLogfileReporter(t *testing.T) {
+	sc := &SystemConfig{Log: LogConfig{LogLevel: "INFO", LogFile: LogFileConfig{Filename: defaultLogFile}}}
+	sc.Metrics.Reporters = []ReporterConfig{
+		{Logfile: &LogfileReporterConfig{}},
+		{Logfile: &LogfileReporterConfig{Level: "debug"}},
+		{Logfile: &LogfileReporterConfig{Level: "verbose"}},
+	}
+	errs := &Errors{}
+	sc.check(errs)
+	if l := sc.Metrics.Reporters[0].Logfile; l.Level != defaultMetricReportLevel || l.ReportInterval != defaultMetricReportInterval {
+		t.Errorf("defaults not applied: %+v", l)
+	}
+	if l := sc.Metrics.Reporters[1].Logfile; l.Level != "debug" {
+		t.Errorf("level changed to %s", l.Level)
+	}
+	if errs.Len() != 1 || !strings.Contains(errs.Err().Error(), "metrics.reporters[2].logfile.level: unknown level: verbose") {
+		t.Errorf("errors: %v", errs.Err())
+	}
+}
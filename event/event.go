@@ -0,0 +1,117 @@
+package event
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var hostname string
+
+func init() {
+	hostname, _ = os.Hostname()
+}
+
+// SetHostname overrides the host name stamped on every new event.
+func SetHostname(host string) {
+	if host != "" {
+		hostname = host
+	}
+}
+
+func Hostname() string {
+	return hostname
+}
+
+// Checkpoint identifies the position in the source that an event was read from,
+// e.g. a file offset or a windows event record id.
+type Checkpoint struct {
+	Key    string
+	Offset uint64
+}
+
+// Acker is notified once every output has finished with an event, so the
+// source can move its checkpoint forward.
+type Acker interface {
+	Ack(cp Checkpoint)
+}
+
+type Event struct {
+	Message    string                 `json:"message"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	Fields     map[string]interface{} `json:"fields"`
+	Checkpoint Checkpoint             `json:"-"`
+	acker      Acker
+	parent     *Event
+	created    time.Time
+	refs       int32
+}
+
+func NewEvent(source string, message string) *Event {
+	now := time.Now()
+	e := &Event{
+		Message:   message,
+		Timestamp: now,
+		Host:      hostname,
+		Source:    source,
+		Fields:    make(map[string]interface{}),
+		created:   now,
+		refs:      1,
+	}
+	return e
+}
+
+func (e *Event) SetAcker(acker Acker) {
+	e.acker = acker
+}
+
+// Retain adds n more pending acknowledgements, one per additional consumer.
+func (e *Event) Retain(n int) {
+	atomic.AddInt32(&e.refs, int32(n))
+}
+
+// Ack releases one pending acknowledgement, the last one commits the checkpoint.
+func (e *Event) Ack() {
+	if atomic.AddInt32(&e.refs, -1) != 0 {
+		return
+	}
+	if e.parent != nil {
+		e.parent.Ack()
+		return
+	}
+	if e.acker != nil {
+		e.acker.Ack(e.Checkpoint)
+	}
+}
+
+// Split creates an event carrying a part of e, e is acknowledged only after
+// all the events split from it are.
+func (e *Event) Split(message string) *Event {
+	child := NewEvent(e.Source, message)
+	child.Timestamp = e.Timestamp
+	child.Host = e.Host
+	for k, v := range e.Fields {
+		child.Fields[k] = v
+	}
+	child.Checkpoint = e.Checkpoint
+	child.created = e.created
+	child.parent = e
+	e.Retain(1)
+	return child
+}
+
+// Created is when the event was read, or taken out of the spool.
+func (e *Event) Created() time.Time {
+	return e.created
+}
+
+func (e *Event) SetField(key string, value interface{}) {
+	e.Fields[key] = value
+}
+
+func (e *Event) GetField(key string) (interface{}, bool) {
+	v, ok := e.Fields[key]
+	return v, ok
+}
@@ -0,0 +1,167 @@
+package filelog
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	CharsetAuto       = "auto"
+	charsetSampleSize = 4096
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// utf16Charsets are split into lines by 2 byte units, UTF-16 without BOM is
+// taken as little endian like the files written on windows.
+var utf16Charsets = map[string]unicode.Endianness{
+	"UTF-16":   unicode.LittleEndian,
+	"UTF-16LE": unicode.LittleEndian,
+	"UTF-16BE": unicode.BigEndian,
+}
+
+// lookupEncoding finds a charset by its IANA name, like Shift_JIS or
+// ISO-8859-1, or by a label browsers know, like gb2312 or latin1. An empty
+// charset passes the bytes through.
+func lookupEncoding(charset string) (encoding.Encoding, error) {
+	name := strings.ToLower(strings.TrimSpace(charset))
+	switch name {
+	case "":
+		return encoding.Nop, nil
+	case "gb1830":
+		//旧版本配置中GB18030的错误写法
+		name = "gb18030"
+	}
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil || e == nil {
+		e, err = htmlindex.Get(name)
+	}
+	if err != nil || e == nil || e == encoding.Replacement {
+		return nil, fmt.Errorf("unknown charset %q", charset)
+	}
+	return e, nil
+}
+
+// lineDecoder splits the content of one file into lines and decodes them. A
+// BOM at the start of the file decides the charset over the configured one,
+// auto also recognizes UTF-16 without BOM and otherwise leaves the charset of
+// the lines which are not valid UTF-8 to a charsetDetector.
+type lineDecoder struct {
+	decoder  *encoding.Decoder
+	detector *charsetDetector
+	newline  []byte
+	bom      []byte
+}
+
+// newLineDecoder picks the charset of a file, head is the start of its content.
+func newLineDecoder(charset string, head []byte) (*lineDecoder, error) {
+	auto := strings.ToLower(strings.TrimSpace(charset)) == CharsetAuto
+	var e encoding.Encoding
+	var order unicode.Endianness
+	var isUTF16 bool
+	if auto {
+		order, isUTF16 = sniffUTF16(head)
+	} else {
+		var err error
+		if e, err = lookupEncoding(charset); err != nil {
+			return nil, err
+		}
+		name, _ := ianaindex.IANA.Name(e)
+		if order, isUTF16 = utf16Charsets[name]; isUTF16 && name == "UTF-16" {
+			if sniffed, ok := sniffUTF16(head); ok {
+				order = sniffed
+			}
+		}
+	}
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return &lineDecoder{decoder: unicode.UTF8.NewDecoder(), newline: []byte{'\n'}, bom: bomUTF8}, nil
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return newUTF16LineDecoder(unicode.LittleEndian, bomUTF16LE), nil
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return newUTF16LineDecoder(unicode.BigEndian, bomUTF16BE), nil
+	case isUTF16:
+		return newUTF16LineDecoder(order, nil), nil
+	case auto:
+		return &lineDecoder{detector: newCharsetDetector(head), newline: []byte{'\n'}}, nil
+	}
+	return &lineDecoder{decoder: e.NewDecoder(), newline: []byte{'\n'}}, nil
+}
+
+func newUTF16LineDecoder(order unicode.Endianness, bom []byte) *lineDecoder {
+	newline := []byte{'\n', 0}
+	if order == unicode.BigEndian {
+		newline = []byte{0, '\n'}
+	}
+	return &lineDecoder{
+		decoder: unicode.UTF16(order, unicode.IgnoreBOM).NewDecoder(),
+		newline: newline,
+		bom:     bom,
+	}
+}
+
+// sniffUTF16 recognizes UTF-16 without BOM by the zero bytes of the ASCII
+// characters, they are the second byte of a unit in little endian.
+func sniffUTF16(head []byte) (unicode.Endianness, bool) {
+	units := len(head) / 2
+	if units < 2 {
+		return unicode.LittleEndian, false
+	}
+	var even, odd int
+	for i := 0; i < units*2; i += 2 {
+		if head[i] == 0 {
+			even++
+		}
+		if head[i+1] == 0 {
+			odd++
+		}
+	}
+	switch {
+	case odd*10 >= units*4 && even*10 < units:
+		return unicode.LittleEndian, true
+	case even*10 >= units*4 && odd*10 < units:
+		return unicode.BigEndian, true
+	}
+	return unicode.LittleEndian, false
+}
+
+func (d *lineDecoder) decode(line []byte) ([]byte, error) {
+	if d.detector != nil {
+		return d.detector.decode(line)
+	}
+	return d.decoder.Bytes(line)
+}
+
+// readHead returns the start of the content of file for newLineDecoder,
+// decompressed if c is set.
+func readHead(file *os.File, c *compression) ([]byte, error) {
+	buf := make([]byte, charsetSampleSize)
+	if c == nil {
+		n, err := file.ReadAt(buf, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	r, err := openDecompressed(file, c, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
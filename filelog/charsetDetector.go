@@ -0,0 +1,188 @@
+package filelog
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"unicode/utf8"
+)
+
+const (
+	minCharsetHits  = 2
+	minCharsetScore = 0.3
+)
+
+var errCharsetUndetected = errors.New("cannot detect the charset of the line, set the charset of the path")
+
+// charsetCandidate is a charset auto chooses from. typical tells whether a
+// decoded rune is usual in the texts of the charset, run is the number of
+// non-ASCII runes in a row it belongs to.
+type charsetCandidate struct {
+	name     string
+	encoding encoding.Encoding
+	typical  func(r rune, run int) bool
+}
+
+// autoCharsets are tried in order, the first one wins a tie.
+var autoCharsets = []charsetCandidate{
+	{"GB18030", simplifiedchinese.GB18030, func(r rune, run int) bool { return commonHan[r] }},
+	{"Big5", traditionalchinese.Big5, func(r rune, run int) bool { return commonHan[r] }},
+	{"Shift_JIS", japanese.ShiftJIS, isJapanese},
+	{"EUC-JP", japanese.EUCJP, isJapanese},
+	{"EUC-KR", korean.EUCKR, func(r rune, run int) bool { return commonHangul[r] }},
+	{"windows-1252", charmap.Windows1252, isLatinLetter},
+}
+
+// commonHan are frequent chinese characters, simplified and traditional, and
+// the ones of log messages. Bytes of another charset decode to rare ones.
+var commonHan = runeSet("的一是不了在人有我他这个们中来上大为和国地到以说时要就出也得里后自会家可下而过天去能对小多然于心学么之都好看起发当没成只如事把还用第样道想作种开" +
+	"美总从无情己面最女但现前些所同日手又行意动方期它头经长儿回位分老因很给名法间知世什两次使身者被高已亲其进此话常与活正感见明问力理点文几定本公特做外孩相西果走将" +
+	"月十实向声车全信重三机工物气每并别真打太新比才便再书部水像眼等体却加电主界门利海受听表德少代员许先口由死安写性马光白或住难望教命花结乐色更拉东神记处让母父应直" +
+	"字场平报友关放至张认接告入笑内英军候民岁往何度山觉路带万男边风解叫任金快原吃变通师立象数四失满战远格士音轻目条呢病始达深完今提求清王化空业思切怎非找片钱吗语" +
+	"元喜离飞科言干流欢约各即指合反题必该论交终林请医晚制球决传画保读运及则房早院量苦火布品近坐产答星精视五连司朋且台夜青北队久乎越观落尽形红百令周识步希术留市半" +
+	"热送兴造谈容极随演收首根讲整式取照办强石古华拿计您装似足双转诉米称客南领节衣站黑刻统断城故历惊脸选包紧争另建维绝树系伤示愿持千史谁准联纪基买志静复痛消社算义" +
+	"确酒需单治卡幸念举仅钟怕共毛句息功官待究跟穿室易游程号居考突皮费倒价图具刚脑永歌响商礼细专黄块脚味灵改据般破引食仍存众注笔某沉血备习校默务土微须试怀料调广显" +
+	"查密议底列富梦错座参八除跑亮假印设线温虽掉初养香停际致阳纸验助激够严证帝饭忘支春集木研班普导展跳获艺六波察群段急庭创区器谢店否害草排背止组朝封板角况曲馆育忙" +
+	"质河续呼若推境遇雨标充围案护冷警著雪索船险依值帮汉慢低玩资屋击速顾团堂兵七园旅街劳型烈异抱宝权简态级票怪寻杀律胜份右范床秘午登楼贵吸责例追较职属渐左录牙继章" +
+	"智冲叶卖坚肉遗救修松临藏担善卫药敢靠词耳差短云规窗散油旧适架投弹铁博府压超负杂醒洗采毫既状乱景席童顶派素脱农疑练野按犯拍征坏余承置彩灯巨免环暗换技翻束增付阵" +
+	"批项休懂武革良委探营退摇弄桌熟宣银势奖宫忽套康供优课降困罪亡健模败守挥财孤禁恐伙迹遍盖副牌江顺秋划授归浪凡预升编典含盛济端招释介烧误" +
+	"户码启异常告警调试配置端口址网络进程线存磁盘参返执任队列消读写删创更库件系统请响应断拒绝访限效败" +
+	"這個們來為國說時會對裡後過還發當沒從與見問現點開長動經種學頭樣麼關機實體將進記處讓應書電話務錯誤連線設檔案資料庫伺服請執間參數傳輸網路訊息狀態啟異備憶碟序緒隊結" +
+	"處理敗帳號碼權訪絕讀寫刪創統錄戶" +
+	"処続済")
+
+// commonHangul are frequent korean syllables.
+var commonHangul = runeSet("이다는의에고하을가지기로서한리사자어도정수인대시해게나니들보있요아었것그일우라오부전중를면과제실성상주장동만국원경무여없계소비생적문습까되합했으며저년월" +
+	"분초류패공연결버파용그번호청답작종료처데터베스설경보디접속못할때내와은")
+
+func runeSet(s string) map[rune]bool {
+	set := make(map[rune]bool, len(s)/3)
+	for _, r := range s {
+		set[r] = true
+	}
+	return set
+}
+
+func isJapanese(r rune, run int) bool {
+	//平假名和片假名
+	return (r >= 0x3041 && r <= 0x30ff) || commonHan[r]
+}
+
+// isLatinLetter takes the accented letters standing alone or in pairs, like
+// é in café or öß in Größe, the two byte characters of CJK charsets come in
+// longer runs of bytes.
+func isLatinLetter(r rune, run int) bool {
+	return run <= 2 && r >= 0xc0 && r <= 0xff && r != 0xd7 && r != 0xf7
+}
+
+// score decodes sample and returns the number of typical runes and their share
+// of the non-ASCII ones, ok is false when sample is not valid in the charset.
+func (c *charsetCandidate) score(sample []byte) (hits int, score float64, ok bool) {
+	text, err := c.encoding.NewDecoder().Bytes(sample)
+	if err != nil {
+		return 0, 0, false
+	}
+	runes := []rune(string(text))
+	total := 0
+	for i := 0; i < len(runes); {
+		if runes[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && runes[j] >= utf8.RuneSelf {
+			j++
+		}
+		for _, r := range runes[i:j] {
+			if r == utf8.RuneError {
+				return 0, 0, false
+			}
+			total++
+			if c.typical(r, j-i) {
+				hits++
+			}
+		}
+		i = j
+	}
+	if total == 0 {
+		return 0, 0, false
+	}
+	return hits, float64(hits) / float64(total), true
+}
+
+// detectCharset chooses the candidate whose decoding of sample looks most like
+// text, it gives up when none or more than one of them is convincing.
+func detectCharset(sample []byte) (*charsetCandidate, error) {
+	var found *charsetCandidate
+	var best, second float64
+	var bestHits int
+	for i := range autoCharsets {
+		c := &autoCharsets[i]
+		hits, score, ok := c.score(sample)
+		switch {
+		case !ok:
+		case score > best:
+			found, bestHits, best, second = c, hits, score, best
+		case score > second:
+			second = score
+		}
+	}
+	if found == nil || bestHits < minCharsetHits || best < minCharsetScore || best < 2*second {
+		return nil, errCharsetUndetected
+	}
+	return found, nil
+}
+
+// charsetDetector decodes the lines of an auto file without BOM which is not
+// UTF-16. Lines valid as UTF-8 are kept, the others are decoded by the charset
+// detected from the start of the file or, if that has no such lines, from the
+// first ones met. Undecided lines are reported and kept as evidence.
+type charsetDetector struct {
+	decoder *encoding.Decoder
+	sample  []byte
+}
+
+func newCharsetDetector(head []byte) *charsetDetector {
+	cd := &charsetDetector{}
+	lines := bytes.SplitAfter(head, []byte{'\n'})
+	sample := make([]byte, 0)
+	for i, line := range lines {
+		//最后一行可能被截断
+		if i == len(lines)-1 && len(head) == charsetSampleSize {
+			break
+		}
+		if !utf8.Valid(line) {
+			sample = append(sample, line...)
+		}
+	}
+	if len(sample) > 0 {
+		if c, err := detectCharset(sample); err == nil {
+			cd.decoder = c.encoding.NewDecoder()
+		}
+	}
+	return cd
+}
+
+func (cd *charsetDetector) decode(line []byte) ([]byte, error) {
+	if utf8.Valid(line) {
+		return line, nil
+	}
+	if cd.decoder == nil {
+		if len(cd.sample)+len(line) >= charsetSampleSize {
+			cd.sample = cd.sample[:0]
+		}
+		cd.sample = append(append(cd.sample, line...), '\n')
+		c, err := detectCharset(cd.sample)
+		if err != nil {
+			return nil, err
+		}
+		cd.decoder = c.encoding.NewDecoder()
+		cd.sample = nil
+	}
+	return cd.decoder.Bytes(line)
+}
@@ -0,0 +1,96 @@
+package filelog
+
+import (
+	"testing"
+)
+
+func encodeCharset(t *testing.T, name string, s string) []byte {
+	t.Helper()
+	for _, c := range autoCharsets {
+		if c.name == name {
+			b, err := c.encoding.NewEncoder().Bytes([]byte(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+	}
+	t.Fatalf("no charset %s", name)
+	return nil
+}
+
+var charsetTexts = []struct {
+	charset string
+	text    string
+}{
+	{"GB18030", "2024-01-02 12:00:01 用户登录失败，密码错误"},
+	{"Big5", "2024-01-02 12:00:01 使用者登入失敗，密碼錯誤"},
+	{"Shift_JIS", "2024-01-02 12:00:01 ユーザーのログインに失敗しました"},
+	{"EUC-JP", "2024-01-02 12:00:01 ユーザーのログインに失敗しました"},
+	{"EUC-KR", "2024-01-02 12:00:01 사용자 로그인에 실패했습니다"},
+	{"windows-1252", "Größe der Datei überschritten, café crème"},
+}
+
+func TestDetectCharset(t *testing.T) {
+	for _, tt := range charsetTexts {
+		c, err := detectCharset(encodeCharset(t, tt.charset, tt.text))
+		if err != nil {
+			t.Errorf("%s: %v", tt.charset, err)
+			continue
+		}
+		if c.name != tt.charset {
+			t.Errorf("%s detected as %s", tt.charset, c.name)
+		}
+	}
+	for _, sample := range [][]byte{
+		{0x81, 0x30, '\n'},
+		encodeCharset(t, "GB18030", "啊"),
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80},
+	} {
+		if c, err := detectCharset(sample); err == nil {
+			t.Errorf("%q detected as %s", sample, c.name)
+		}
+	}
+}
+
+func TestLineDecoderAutoDetectsFromHead(t *testing.T) {
+	for _, tt := range charsetTexts {
+		head := append([]byte("service started\n"), encodeCharset(t, tt.charset, tt.text+"\n")...)
+		d, err := newLineDecoder(CharsetAuto, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//检测只依据文件开头，之后的短行也按检测出的字符集转换
+		runes := []rune(tt.text)
+		tail := string(runes[len(runes)-3:])
+		got, err := d.decode(encodeCharset(t, tt.charset, tail))
+		if err != nil {
+			t.Errorf("%s: %v", tt.charset, err)
+			continue
+		}
+		if string(got) != tail {
+			t.Errorf("%s decoded %q", tt.charset, got)
+		}
+	}
+}
+
+func TestLineDecoderAutoGathersEvidence(t *testing.T) {
+	d, err := newLineDecoder(CharsetAuto, []byte("service started\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.decode(encodeCharset(t, "Shift_JIS", "失")); err == nil {
+		t.Fatalf("one character decoded as %q", got)
+	}
+	got, err := d.decode(encodeCharset(t, "Shift_JIS", "ログイン"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ログイン" {
+		t.Fatalf("decoded %q", got)
+	}
+	got, err = d.decode(encodeCharset(t, "Shift_JIS", "失"))
+	if err != nil || string(got) != "失" {
+		t.Fatalf("decoded %q %v", got, err)
+	}
+}
@@ -0,0 +1,118 @@
+package filelog
+
+import (
+	"bytes"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+	"testing"
+)
+
+func encodeUTF16(t *testing.T, order unicode.Endianness, s string) []byte {
+	t.Helper()
+	b, err := unicode.UTF16(order, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func encodeGB18030(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := simplifiedchinese.GB18030.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLineDecoder(t *testing.T) {
+	le := encodeUTF16(t, unicode.LittleEndian, "日志 line\n")
+	be := encodeUTF16(t, unicode.BigEndian, "日志 line\n")
+	gb := encodeGB18030(t, "日志 line\n")
+	tests := []struct {
+		name    string
+		charset string
+		head    []byte
+		bom     []byte
+		newline []byte
+	}{
+		{"utf-8 bom", "gbk", append(append([]byte{}, bomUTF8...), "日志 line\n"...), bomUTF8, []byte("\n")},
+		{"utf-16le bom", "", append(append([]byte{}, bomUTF16LE...), le...), bomUTF16LE, []byte("\n\x00")},
+		{"utf-16be bom", "auto", append(append([]byte{}, bomUTF16BE...), be...), bomUTF16BE, []byte("\x00\n")},
+		{"auto utf-16le", "auto", le, nil, []byte("\n\x00")},
+		{"auto utf-16be", "AUTO", be, nil, []byte("\x00\n")},
+		{"utf-16 sniffed big endian", "utf-16", be, nil, []byte("\x00\n")},
+		{"utf-16le", "UTF-16LE", le, nil, []byte("\n\x00")},
+		{"auto gb18030", "auto", gb, nil, []byte("\n")},
+		{"gb1830 alias", "gb1830", gb, nil, []byte("\n")},
+		{"latin1 label", "latin1", []byte("caf\xe9 line\n"), nil, []byte("\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newLineDecoder(tt.charset, tt.head)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d.bom, tt.bom) || !bytes.Equal(d.newline, tt.newline) {
+				t.Fatalf("bom %q newline %q, want %q %q", d.bom, d.newline, tt.bom, tt.newline)
+			}
+			content := bytes.TrimPrefix(tt.head, tt.bom)
+			line, err := d.decode(content[:len(content)-len(d.newline)])
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "日志 line"
+			if tt.charset == "latin1" {
+				want = "café line"
+			}
+			if string(line) != want {
+				t.Errorf("decoded %q, want %q", line, want)
+			}
+		})
+	}
+}
+
+func TestLineDecoderAutoKeepsUTF8(t *testing.T) {
+	d, err := newLineDecoder(CharsetAuto, []byte("日志\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range [][]byte{[]byte("日志"), encodeGB18030(t, "日志")} {
+		got, err := d.decode(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "日志" {
+			t.Errorf("decode(%q) = %q", line, got)
+		}
+	}
+}
+
+func TestSniffUTF16(t *testing.T) {
+	tests := []struct {
+		name    string
+		head    []byte
+		order   unicode.Endianness
+		isUTF16 bool
+	}{
+		{"ascii", []byte("plain ascii line\n"), unicode.LittleEndian, false},
+		{"too short", []byte{'a', 0}, unicode.LittleEndian, false},
+		{"little endian", encodeUTF16(t, unicode.LittleEndian, "abc def\n"), unicode.LittleEndian, true},
+		{"big endian", encodeUTF16(t, unicode.BigEndian, "abc def\n"), unicode.BigEndian, true},
+		{"binary zeros", make([]byte, 16), unicode.LittleEndian, false},
+	}
+	for _, tt := range tests {
+		order, ok := sniffUTF16(tt.head)
+		if ok != tt.isUTF16 || (ok && order != tt.order) {
+			t.Errorf("%s: sniffUTF16 = %v %v, want %v %v", tt.name, order, ok, tt.order, tt.isUTF16)
+		}
+	}
+}
+
+func TestLookupEncodingUnknown(t *testing.T) {
+	for _, charset := range []string{"nope", "replacement", "utf-99"} {
+		if _, err := newLineDecoder(charset, nil); err == nil {
+			t.Errorf("charset %q accepted", charset)
+		}
+	}
+}
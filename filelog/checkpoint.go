@@ -0,0 +1,195 @@
+package filelog
+
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/record"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	FileCheckpointFile = "file"
+	FileCheckpointDir  = "dir"
+)
+
+// FileCheckpoint is the read position of the file at Path, it does not depend
+// on the file identity so it can be moved to another host. Type tells which
+// reader it belongs to, a file path or a file of a directory path.
+type FileCheckpoint struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Offset uint64 `json:"offset"`
+	Key    string `json:"-"`
+}
+
+func keyPrefix(template string) string {
+	return strings.TrimSuffix(template, "%s")
+}
+
+// IsFileCheckpointKey tells whether key is stored by the file readers, either
+// an offset or the metadata kept next to it.
+func IsFileCheckpointKey(key string) bool {
+	return strings.HasPrefix(key, keyPrefix(recordpointFileLogTemplate)) || strings.HasPrefix(key, keyPrefix(recordpointDirLogTemplate))
+}
+
+// IsMetadataKey tells whether key holds a file identity or fingerprint rather than an offset.
+func IsMetadataKey(key string) bool {
+	return strings.HasPrefix(key, keyPrefix(recordpointPathIDTemplate)) || strings.HasPrefix(key, keyPrefix(recordpointFingerprintTmpl)) ||
+		strings.HasPrefix(key, keyPrefix(recordpointDoneTemplate)) || strings.HasPrefix(key, keyPrefix(recordpointDirPathTemplate))
+}
+
+// FileCheckpoints lists the positions by path, the identity of the file now
+// at a path is resolved through its filelog-path or dirlog-path key.
+func FileCheckpoints(ck *record.RecordPoint) ([]FileCheckpoint, error) {
+	entries, err := ck.Entries()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(entries))
+	for _, e := range entries {
+		values[e.Key] = e.Value
+	}
+	checkpoints := make([]FileCheckpoint, 0)
+	add := func(path string, checkpointType string, key string) error {
+		value, ok := values[key]
+		if !ok {
+			return nil
+		}
+		offset, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset of %s: %s", key, value)
+		}
+		checkpoints = append(checkpoints, FileCheckpoint{Path: path, Type: checkpointType, Offset: offset, Key: key})
+		return nil
+	}
+	for _, e := range entries {
+		var err error
+		switch {
+		case strings.HasPrefix(e.Key, keyPrefix(recordpointPathIDTemplate)):
+			path := strings.TrimPrefix(e.Key, keyPrefix(recordpointPathIDTemplate))
+			err = add(path, FileCheckpointFile, fmt.Sprintf(recordpointFileIDTemplate, e.Value))
+		case strings.HasPrefix(e.Key, keyPrefix(recordpointDirPathTemplate)):
+			path := strings.TrimPrefix(e.Key, keyPrefix(recordpointDirPathTemplate))
+			err = add(path, FileCheckpointDir, fmt.Sprintf(recordpointFileIDTemplate, e.Value))
+		case strings.HasPrefix(e.Key, keyPrefix(recordpointDirLogTemplate)):
+			err = add(strings.TrimPrefix(e.Key, keyPrefix(recordpointDirLogTemplate)), FileCheckpointDir, e.Key)
+		case strings.HasPrefix(e.Key, keyPrefix(recordpointFileLogTemplate)) && !IsMetadataKey(e.Key) &&
+			!strings.HasPrefix(e.Key, keyPrefix(recordpointFileIDTemplate)):
+			err = add(strings.TrimPrefix(e.Key, keyPrefix(recordpointFileLogTemplate)), FileCheckpointFile, e.Key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return checkpoints, nil
+}
+
+// FindFileCheckpoint returns the checkpoint of the file at path.
+func FindFileCheckpoint(ck *record.RecordPoint, path string) (FileCheckpoint, bool, error) {
+	checkpoints, err := FileCheckpoints(ck)
+	if err != nil {
+		return FileCheckpoint{}, false, err
+	}
+	for _, c := range checkpoints {
+		if c.Path == path {
+			return c, true, nil
+		}
+	}
+	return FileCheckpoint{}, false, nil
+}
+
+// SetFileCheckpoint stores the offset of the file at path. A file path not
+// read yet gets a path keyed offset, which the reader moves to the identity
+// of the file when it opens it, this is also how a checkpoint taken on
+// another host is applied.
+func SetFileCheckpoint(ck *record.RecordPoint, path string, checkpointType string, offset uint64) (string, error) {
+	key := fmt.Sprintf(recordpointFileLogTemplate, path)
+	pathKey := fmt.Sprintf(recordpointPathIDTemplate, path)
+	if checkpointType == FileCheckpointDir {
+		key = fmt.Sprintf(recordpointDirLogTemplate, path)
+		pathKey = fmt.Sprintf(recordpointDirPathTemplate, path)
+	}
+	id, err := ck.GetValue(pathKey)
+	if err != nil {
+		return "", err
+	}
+	if id != "" {
+		ck.DelCheckpoint(key)
+		//文件已经读完时删除完成的记录，从新的位置继续读取
+		ck.DelCheckpoint(fmt.Sprintf(recordpointDoneTemplate, id))
+		key = fmt.Sprintf(recordpointFileIDTemplate, id)
+	}
+	ck.SetCheckpoint(key, offset)
+	return key, nil
+}
+
+// ResetFileCheckpoint deletes everything stored about the file at path, it
+// is read from the beginning the next time. The deleted keys are returned.
+func ResetFileCheckpoint(ck *record.RecordPoint, path string) ([]string, error) {
+	keys := []string{
+		fmt.Sprintf(recordpointPathIDTemplate, path),
+		fmt.Sprintf(recordpointDirPathTemplate, path),
+		fmt.Sprintf(recordpointFileLogTemplate, path),
+		fmt.Sprintf(recordpointDirLogTemplate, path),
+	}
+	ids := make(map[string]bool)
+	for _, pathKey := range keys[:2] {
+		id, err := ck.GetValue(pathKey)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			ids[id] = true
+		}
+	}
+	//路径到文件标识的记录可能已经过时，同时按现有文件的标识删除
+	if file, err := os.Open(path); err == nil {
+		fileID, err := getFileIdentity(file)
+		file.Close()
+		if err == nil {
+			ids[fileID.String()] = true
+		}
+	}
+	for id := range ids {
+		keys = append(keys, fmt.Sprintf(recordpointFileIDTemplate, id), fmt.Sprintf(recordpointFingerprintTmpl, id),
+			fmt.Sprintf(recordpointDoneTemplate, id))
+	}
+	deleted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value, err := ck.GetValue(key)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			ck.DelCheckpoint(key)
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted, nil
+}
+
+// fileDone tells whether the file with identity id was read to its end and
+// is not written anymore, the fingerprint guards against a reused identity.
+func fileDone(ck *record.RecordPoint, file *os.File, id fileIdentity) (bool, error) {
+	fp, err := ck.GetValue(fmt.Sprintf(recordpointDoneTemplate, id))
+	if err != nil || fp == "" {
+		return false, err
+	}
+	return matchFingerprint(file, fp), nil
+}
+
+// completeFile marks the file as read once everything read under key is
+// acknowledged, for compressed and rotated files which are never appended to.
+func completeFile(ck *record.RecordPoint, file *os.File, id fileIdentity, key string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	fp, err := fingerprint(file, info.Size())
+	if err != nil {
+		return err
+	}
+	ck.CompleteCheckpoint(key, fmt.Sprintf(recordpointDoneTemplate, id), fp)
+	return nil
+}
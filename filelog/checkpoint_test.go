@@ -0,0 +1,43 @@
+package filelog
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileCheckpointSetAndReset(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "app.log")
+	writeFile(t, path, []byte("l1\nl2\nl3\n"))
+	queue := make(chan *event.Event, 10)
+
+	//导入的位置按路径保存，读取器打开文件时换成文件标识
+	key, err := SetFileCheckpoint(ck, path, FileCheckpointFile, 3)
+	if err != nil || key != "filelog-"+path {
+		t.Fatalf("set checkpoint %q %v", key, err)
+	}
+	checkLines(t, "read from the imported offset", readAll(newTestFileReader(path, ck, queue), queue), "l2", "l3")
+	c, ok, err := FindFileCheckpoint(ck, path)
+	if err != nil || !ok || c.Offset != 9 || !strings.HasPrefix(c.Key, "filelog-id-") {
+		t.Fatalf("checkpoint %+v %v %v after reading", c, ok, err)
+	}
+	if v, _ := ck.GetValue("filelog-" + path); v != "" {
+		t.Errorf("path keyed offset %q left", v)
+	}
+
+	if key, err = SetFileCheckpoint(ck, path, FileCheckpointFile, 6); err != nil || key != c.Key {
+		t.Fatalf("set checkpoint %q %v, want %s", key, err, c.Key)
+	}
+	checkLines(t, "read from the set offset", readAll(newTestFileReader(path, ck, queue), queue), "l3")
+
+	deleted, err := ResetFileCheckpoint(ck, path)
+	if err != nil || len(deleted) == 0 {
+		t.Fatalf("reset deleted %v %v", deleted, err)
+	}
+	if _, ok, _ := FindFileCheckpoint(ck, path); ok {
+		t.Error("checkpoint left after reset")
+	}
+	checkLines(t, "read after reset", readAll(newTestFileReader(path, ck, queue), queue), "l1", "l2", "l3")
+}
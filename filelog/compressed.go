@@ -0,0 +1,88 @@
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// compression is a format of compressed files, recognized by the magic bytes
+// at their start whatever their name is. check looks at the bytes after a
+// magic short enough to start a line of text.
+type compression struct {
+	name  string
+	magic []byte
+	check func(head []byte) bool
+	open  func(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// isBzip2 requires the block size digit of BZh1-BZh9 and the magic of the
+// first block, or of the end of an empty stream.
+func isBzip2(head []byte) bool {
+	if len(head) < 10 || head[3] < '1' || head[3] > '9' {
+		return false
+	}
+	return bytes.Equal(head[4:10], bzip2BlockMagic) || bytes.Equal(head[4:10], bzip2EndMagic)
+}
+
+var compressions = []*compression{
+	{name: "gzip", magic: []byte{0x1f, 0x8b}, open: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}},
+	{name: "bzip2", magic: []byte("BZh"), check: isBzip2, open: func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	}},
+	{name: "zstd", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, open: func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}},
+}
+
+// detectCompression returns nil for a plain file.
+func detectCompression(file *os.File) (*compression, error) {
+	buf := make([]byte, 10)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	for _, c := range compressions {
+		if bytes.HasPrefix(buf[:n], c.magic) && (c.check == nil || c.check(buf[:n])) {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// openDecompressed returns the content of file from offset on, offsets of a
+// compressed file count the uncompressed bytes so the content before offset
+// is decompressed and dropped.
+func openDecompressed(file *os.File, c *compression, offset uint64) (io.ReadCloser, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r, err := c.open(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("open %s file error: %v", c.name, err)
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(offset)); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("skip to offset %d of %s file error: %v", offset, c.name, err)
+		}
+	}
+	return r, nil
+}
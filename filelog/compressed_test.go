@@ -0,0 +1,174 @@
+package filelog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bzip2Lines is "a1\na2\n" compressed by bzip2 -9.
+var bzip2Lines = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x19, 0x4c,
+	0xf4, 0x28, 0x00, 0x00, 0x02, 0x49, 0x00, 0x00, 0x10, 0x30, 0x00, 0x20,
+	0x00, 0x20, 0x00, 0x30, 0xcd, 0x34, 0x18, 0xc8, 0x0c, 0x67, 0x17, 0x72,
+	0x45, 0x38, 0x50, 0x90, 0x19, 0x4c, 0xf4, 0x28,
+}
+
+func newTestFileReader(path string, ck *record.RecordPoint, queue chan *event.Event) *FileLogReader {
+	mr := metrics.NewMetricRegstry()
+	mr.RegisterMetric(metrics.NewMeter("test-fileread-rate"))
+	mr.RegisterMetric(metrics.NewCounter("test-record-total"))
+	return CreateFileLogReader("test", path, "", nil, nil, ck, queue, mr)
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	_, dir := newTestCheckpoint(t)
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"bzip2", bzip2Lines, "bzip2"},
+		{"empty bzip2", []byte{0x42, 0x5a, 0x68, 0x39, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0, 0, 0, 0}, "bzip2"},
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, "gzip"},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, "zstd"},
+		{"text starting with BZh", []byte("BZhang login ok\n"), ""},
+		{"text starting with a bzip2 level", []byte("BZh9 is a block size\n"), ""},
+		{"short text", []byte("BZh"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "file")
+		writeFile(t, path, tt.content)
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := detectCompression(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := ""
+		if c != nil {
+			got = c.name
+		}
+		if got != tt.want {
+			t.Errorf("%s detected as %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadPlainFileStartingWithBZh(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "auth.log")
+	writeFile(t, path, []byte("BZhang login ok\nBZhang logout\n"))
+	queue := make(chan *event.Event, 10)
+	checkLines(t, "plain file", readAll(newTestFileReader(path, ck, queue), queue), "BZhang login ok", "BZhang logout")
+}
+
+func compressLines(t *testing.T, format string, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch format {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	case "bzip2":
+		if content != "a1\na2\n" {
+			t.Fatalf("no bzip2 data of %q", content)
+		}
+		return bzip2Lines
+	}
+	w.Write([]byte(content))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func fileID(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	id, err := getFileIdentity(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id.String()
+}
+
+func TestCompressedFileOffsets(t *testing.T) {
+	for _, format := range []string{"gzip", "zstd", "bzip2"} {
+		t.Run(format, func(t *testing.T) {
+			ck, dir := newTestCheckpoint(t)
+			path := filepath.Join(dir, "app.log.1")
+			writeFile(t, path, compressLines(t, format, "a1\na2\n"))
+			id := fileID(t, path)
+			queue := make(chan *event.Event, 10)
+			newTestFileReader(path, ck, queue).Read()
+			events := make([]*event.Event, 0)
+			for len(queue) > 0 {
+				events = append(events, <-queue)
+			}
+			//位置是解压后的偏移量
+			if len(events) != 2 || events[0].Checkpoint.Offset != 3 || events[1].Checkpoint.Offset != 6 {
+				t.Fatalf("read %d events", len(events))
+			}
+			events[0].Ack()
+			if done, _ := ck.GetValue("filelog-done-" + id); done != "" {
+				t.Fatal("file completed before all its events were acknowledged")
+			}
+			if offset, _ := ck.GetCheckpoint("filelog-id-" + id); offset != 3 {
+				t.Fatalf("checkpoint %d, want 3", offset)
+			}
+			//重启后从解压后的位置继续读取
+			checkLines(t, "resumed", readAll(newTestFileReader(path, ck, queue), queue), "a2")
+			events[1].Ack()
+			if done, _ := ck.GetValue("filelog-done-" + id); done == "" {
+				t.Fatal("file not completed")
+			}
+			if offset, _ := ck.GetCheckpoint("filelog-id-" + id); offset != 0 {
+				t.Errorf("checkpoint %d left after completion", offset)
+			}
+			checkLines(t, "read again", readAll(newTestFileReader(path, ck, queue), queue))
+		})
+	}
+}
+
+func TestCompressedFileStartPositionEnd(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "app.log.1.gz")
+	writeFile(t, path, compressLines(t, "gzip", "a1\na2\n"))
+	queue := make(chan *event.Event, 10)
+	r := newStartReader(t, PathConfig{Path: path, StartPosition: "end"}, ck, queue)
+	checkLines(t, "start at the end", readAll(r, queue))
+	if done, _ := ck.GetValue("filelog-done-" + fileID(t, path)); done == "" {
+		t.Fatal("skipped file not completed")
+	}
+	checkLines(t, "read again", readAll(newTestFileReader(path, ck, queue), queue))
+}
@@ -1,53 +1,69 @@
 package filelog
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
 	"github.com/lucky-abc/cleat/record"
-	"golang.org/x/text/encoding"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
 
-const recordpointDirLogTemplate = "dirlog-%s"
+const (
+	recordpointDirLogTemplate  = "dirlog-%s"
+	recordpointDirPathTemplate = "dirlog-path-%s"
+)
 
 type DirReader struct {
 	dirPath          string
 	ck               *record.RecordPoint
-	queue            chan string
+	queue            chan *event.Event
 	cancelContext    context.Context
 	cancelFun        func()
-	decoder          *encoding.Decoder
+	charset          string
 	readFlag         int32 //0:读取未执行，1：正在读取
 	waitGroup        sync.WaitGroup
+	positions        map[string]uint64
+	finished         map[string]bool
+	paths            map[string]string
+	filter           *fileFilter
+	multiline        *MultilineConfig
+	start            *startPolicy
+	filtering        map[string]bool
 	readMeter        *metrics.Meter
 	fileNumMetric    *metrics.Counter
 	recorTotalMetric *metrics.Counter
 }
 
-func CreateDirReader(path string, charset string, ck *record.RecordPoint, queue chan string, metricRegistry *metrics.MetricRegistry) *DirReader {
+func CreateDirReader(tunnelName string, path string, charset string, filter *fileFilter, multiline *MultilineConfig, start *startPolicy, ck *record.RecordPoint, queue chan *event.Event, metricRegistry *metrics.MetricRegistry) *DirReader {
 	r := &DirReader{
-		dirPath: path,
-		ck:      ck,
-		queue:   queue,
+		dirPath:   path,
+		ck:        ck,
+		queue:     queue,
+		positions: make(map[string]uint64),
+		finished:  make(map[string]bool),
+		paths:     make(map[string]string),
+		filter:    filter,
+		multiline: multiline,
+		start:     start,
+		filtering: make(map[string]bool),
 	}
 	context, cancelf := context.WithCancel(context.Background())
 	r.cancelContext = context
 	r.cancelFun = cancelf
-	decoder := NewMessageDecoder(charset)
-	r.decoder = decoder
+	r.charset = charset
 
-	r.readMeter = metricRegistry.GetMeter("directoryread-rate")
-	r.fileNumMetric = metricRegistry.GetCounter("directory-filenum")
-	r.recorTotalMetric = metricRegistry.GetCounter("filelog-record-total")
+	r.readMeter = metricRegistry.GetMeter(tunnelName + "-directoryread-rate")
+	r.fileNumMetric = metricRegistry.GetCounter(tunnelName + "-directory-filenum")
+	r.recorTotalMetric = metricRegistry.GetCounter(tunnelName + "-record-total")
 	return r
 }
 
@@ -56,6 +72,7 @@ func (dr *DirReader) Read() {
 	dr.waitGroup.Add(1)
 	defer func() {
 		atomic.StoreInt32(&dr.readFlag, 0)
+		dr.start = dr.start.forNewFiles()
 		dr.waitGroup.Done()
 	}()
 	dir := dr.dirPath
@@ -64,93 +81,309 @@ func (dr *DirReader) Read() {
 		logger.Loggers().Errorf("get sub file or directory error：%s,%v", dir, err)
 		return
 	}
-	var ffi []os.FileInfo = make([]os.FileInfo, 0)
+	files := make([]*dirFile, 0, len(fss))
+	paths := make(map[string]bool, len(fss))
+	ids := make(map[string]bool, len(fss))
+	gone := make(map[string]bool)
 	//排除掉目录
-	for _, f := range fss {
-		if f.IsDir() {
+	for _, info := range fss {
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() || !dr.filter.accept(path) {
+			continue
+		}
+		paths[path] = true
+		f, err := dr.openDirFile(path, info)
+		if err != nil {
+			logger.Loggers().Errorf("open file error: %s,%v", path, err)
 			continue
 		}
-		ffi = append(ffi, f)
+		ids[f.id.String()] = true
+		if last, ok := dr.paths[path]; ok && last != f.id.String() {
+			gone[last] = true
+		}
+		if !dr.mapPath(path, f.id) || dr.finished[f.key] {
+			continue
+		}
+		files = append(files, f)
+	}
+	for path, id := range dr.paths {
+		if !paths[path] {
+			dr.ck.DelCheckpoint(fmt.Sprintf(recordpointDirPathTemplate, path))
+			delete(dr.paths, path)
+			gone[id] = true
+		}
+	}
+	for id := range gone {
+		if !ids[id] {
+			dr.forget(id)
+		}
 	}
-	sort.Slice(ffi, func(i, j int) bool {
-		return ffi[i].Name() < ffi[j].Name()
+	sort.Slice(files, func(i, j int) bool {
+		return olderFile(files[i].info, files[j].info)
 	})
-	//for _, info := range ffi {
-	//	logger.Loggers().Debug(info.Name())
-	//
-	//}
-	var lastFileIndex int
-	for i, info := range ffi {
-		offset, err := dr.ck.GetCheckpoint(fmt.Sprintf(recordpointDirLogTemplate, filepath.Join(dr.dirPath, info.Name())))
+	//压缩文件是轮转后的归档，不会再写入，只按未压缩的文件判断从哪个文件开始读取以及哪个文件仍在写入
+	firstFileIndex, lastFileIndex := -1, -1
+	for i, f := range files {
+		if f.compression != nil {
+			continue
+		}
+		lastFileIndex = i
+		if firstFileIndex >= 0 {
+			continue
+		}
+		offset, _, err := dr.offset(f)
 		if err != nil {
-			logger.Loggers().Errorf("get file recordpoint error1：%s,%v", info.Name(), err)
+			logger.Loggers().Errorf("get file recordpoint error1：%s,%v", f.path, err)
 			return
 		}
 		if offset > 0 {
-			logger.Loggers().Error(info.Name(), offset)
-			lastFileIndex = i
-			break
+			firstFileIndex = i
 		}
 	}
-	ffi = ffi[lastFileIndex:]
-	for i, info := range ffi {
-		fileAbsPath := filepath.Join(dr.dirPath, info.Name())
-		offset, err := dr.ck.GetCheckpoint(fmt.Sprintf(recordpointDirLogTemplate, fileAbsPath))
-		if err != nil {
-			logger.Loggers().Errorf("get file recordpoint error2：%s,%v", fileAbsPath, err)
-			return
+	for i, f := range files {
+		if f.compression == nil && i < firstFileIndex {
+			//比已有读取位置的文件更早的文件已经读完，旧版本读完后只删除了读取位置
+			dr.skipFile(f)
+			continue
 		}
-		file, err := os.Open(fileAbsPath)
-		if err != nil {
-			logger.Loggers().Errorf("open file error: %s,%v", fileAbsPath, err)
+		final := f.compression != nil || i < lastFileIndex
+		if !dr.readFile(f, final) {
 			return
 		}
+	}
+}
+
+// dirFile is a file of the directory which was not read to its end yet, key
+// is the checkpoint key of its identity.
+type dirFile struct {
+	info        os.FileInfo
+	path        string
+	id          fileIdentity
+	key         string
+	compression *compression
+}
+
+// openDirFile identifies the file at path, a file read to its end before is
+// marked finished.
+func (dr *DirReader) openDirFile(path string, info os.FileInfo) (*dirFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f := &dirFile{info: info, path: path}
+	if f.id, err = getFileIdentity(file); err != nil {
+		return nil, err
+	}
+	f.key = fmt.Sprintf(recordpointFileIDTemplate, f.id)
+	if dr.finished[f.key] {
+		return f, nil
+	}
+	if f.compression, err = detectCompression(file); err != nil {
+		return nil, err
+	}
+	done, err := fileDone(dr.ck, file, f.id)
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		dr.finished[f.key] = true
+	}
+	return f, nil
+}
+
+// mapPath records the identity of the file at path for the checkpoint
+// commands, it returns false if the store can not be read.
+func (dr *DirReader) mapPath(path string, id fileIdentity) bool {
+	if dr.paths[path] == id.String() {
+		return true
+	}
+	key := fmt.Sprintf(recordpointDirPathTemplate, path)
+	last, err := dr.ck.GetValue(key)
+	if err != nil {
+		logger.Loggers().Errorf("get file identity recordpoint error: %s,%v", path, err)
+		return false
+	}
+	if last != id.String() {
+		dr.ck.SetValue(key, id.String())
+	}
+	dr.paths[path] = id.String()
+	return true
+}
+
+// forget deletes what is kept about a file removed from the directory.
+func (dr *DirReader) forget(id string) {
+	key := fmt.Sprintf(recordpointFileIDTemplate, id)
+	delete(dr.positions, key)
+	delete(dr.filtering, key)
+	delete(dr.finished, key)
+	dr.ck.DelCheckpoint(fmt.Sprintf(recordpointFingerprintTmpl, id))
+	dr.ck.DelCheckpoint(fmt.Sprintf(recordpointDoneTemplate, id))
+}
+
+// olderFile orders the files of a directory by modification time. Rotated
+// copies carry a suffix after the name of the file, e.g. app.log.1, so at the
+// same time the name extending the other one is the older file.
+func olderFile(a os.FileInfo, b os.FileInfo) bool {
+	if !a.ModTime().Equal(b.ModTime()) {
+		return a.ModTime().Before(b.ModTime())
+	}
+	switch an, bn := a.Name(), b.Name(); {
+	case strings.HasPrefix(an, bn):
+		return an != bn
+	case strings.HasPrefix(bn, an):
+		return false
+	default:
+		return an < bn
+	}
+}
+
+// skipFile marks a file as read without reading it.
+func (dr *DirReader) skipFile(f *dirFile) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if id, err := getFileIdentity(file); err != nil || id != f.id {
+		return
+	}
+	dr.finished[f.key] = true
+	if err := completeFile(dr.ck, file, f.id, f.key); err != nil {
+		logger.Loggers().Errorf("complete file error: %s,%v", f.path, err)
+	}
+}
+
+// readFile sends the lines of f from its checkpoint on, final is set for the
+// files which are not written anymore. It returns false if reading the
+// directory has to stop.
+func (dr *DirReader) readFile(f *dirFile, final bool) bool {
+	file, err := os.Open(f.path)
+	if err != nil {
+		logger.Loggers().Errorf("open file error: %s,%v", f.path, err)
+		return false
+	}
+	defer file.Close()
+	if id, err := getFileIdentity(file); err != nil || id != f.id {
+		//列出目录后文件被轮转，下次扫描时按新的文件读取
+		logger.Loggers().Debugf("file changed since the directory was listed: %s", f.path)
+		return true
+	}
+	info, err := file.Stat()
+	if err != nil {
+		logger.Loggers().Errorf("get file info error: %s,%v", f.path, err)
+		return false
+	}
+	offset, checkpointed, err := dr.offset(f)
+	if err != nil {
+		logger.Loggers().Errorf("get file recordpoint error2：%s,%v", f.path, err)
+		return false
+	}
+	if f.compression == nil {
+		if offset, err = verifyOffset(dr.ck, file, info, f.id, offset); err != nil {
+			logger.Loggers().Errorf("get file recordpoint error2：%s,%v", f.path, err)
+			return false
+		}
+	}
+	if !checkpointed {
+		var filter bool
+		offset, filter = dr.start.initialOffset(f.path, info)
+		if offset > 0 && f.compression != nil {
+			dr.finished[f.key] = true
+			if err := completeFile(dr.ck, file, f.id, f.key); err != nil {
+				logger.Loggers().Errorf("complete compressed file error: %s,%v", f.path, err)
+			}
+			return true
+		}
 		if offset > 0 {
-			file.Seek(int64(offset), 0)
+			dr.ck.SetCheckpoint(f.key, offset)
 		}
-		reader := bufio.NewReader(file)
-		dr.fileNumMetric.Incr(1)
-		for {
-			line, err := reader.ReadBytes('\n')
-			if err != nil {
-				if err == io.EOF {
-					logger.Loggers().Infof("File read complete：%s", fileAbsPath)
-					file.Close()
-					dr.fileNumMetric.Decr(1)
-					if i < len(ffi)-1 {
-						dr.ck.DelCheckpoint(fmt.Sprintf(recordpointDirLogTemplate, fileAbsPath))
+		if filter {
+			dr.filtering[f.key] = true
+		}
+	}
+	head, err := readHead(file, f.compression)
+	if err != nil {
+		logger.Loggers().Errorf("read file error: %s,%v", f.path, err)
+		return f.compression != nil
+	}
+	decoder, err := newLineDecoder(dr.charset, head)
+	if err != nil {
+		logger.Loggers().Errorf("get file charset error: %s,%v", f.path, err)
+		return false
+	}
+	var content io.Reader = file
+	if f.compression != nil {
+		r, err := openDecompressed(file, f.compression, offset)
+		if err != nil {
+			logger.Loggers().Errorf("read compressed file error: %s,%v", f.path, err)
+			return true
+		}
+		defer r.Close()
+		logger.Loggers().Infof("read %s compressed file: %s", f.compression.name, f.path)
+		content = r
+	} else if offset > 0 {
+		file.Seek(int64(offset), 0)
+	}
+	ml, _ := newMultiline(dr.multiline)
+	reader := newLineReader(content, offset, decoder, ml)
+	dr.fileNumMetric.Incr(1)
+	defer dr.fileNumMetric.Decr(1)
+	for {
+		msg, end, err := reader.next(dr.cancelContext, final)
+		if err != nil {
+			if err == io.EOF {
+				logger.Loggers().Infof("File read complete：%s", f.path)
+				saveSkipped(dr.ck, dr.filtering, dr.positions, f.key)
+				if final {
+					delete(dr.positions, f.key)
+					dr.finished[f.key] = true
+					if err := completeFile(dr.ck, file, f.id, f.key); err != nil {
+						logger.Loggers().Errorf("complete file error: %s,%v", f.path, err)
 					}
-					break
 				}
-				logger.Loggers().Errorf("file read error：%s,%v", fileAbsPath, err)
-				return
+				return true
 			}
-			offset += uint64(len(line))
-			offset += uint64(len(line))
-			toline, err := dr.decoder.Bytes(line[:len(line)-1])
-			if err != nil {
-				logger.Loggers().Warnf("character encoding conversion error：%v", err)
-				continue
+			if dr.cancelContext.Err() != nil {
+				return false
 			}
-			msg := string(toline)
-			//logger.Loggers().Debug(msg)
-		Lbl:
-			for {
-				select {
-				case dr.queue <- msg:
-					dr.readMeter.Update(1)
-					dr.recorTotalMetric.Incr(1)
-					dr.ck.SetCheckpoint(fmt.Sprintf(recordpointDirLogTemplate, fileAbsPath), offset)
-					break Lbl
-				case <-dr.cancelContext.Done():
-					logger.Loggers().Debugf("end of file read: %s", fileAbsPath)
-					return
-				}
+			logger.Loggers().Errorf("file read error：%s,%v", f.path, err)
+			//损坏或者正在写入的压缩文件不影响目录中其他文件的读取，下次扫描时从记录的位置重新读取
+			return f.compression != nil
+		}
+		if dr.start.skip(dr.filtering, f.key, msg) {
+			dr.positions[f.key] = end
+			continue
+		}
+		e := event.NewEvent(dr.dirPath, msg)
+		e.SetField("file", f.path)
+		e.SetField("offset", end)
+		e.Checkpoint = event.Checkpoint{Key: f.key, Offset: end}
+		dr.ck.Track(e)
+	Lbl:
+		for {
+			select {
+			case dr.queue <- e:
+				dr.positions[f.key] = end
+				dr.readMeter.Update(1)
+				dr.recorTotalMetric.Incr(1)
+				break Lbl
+			case <-dr.cancelContext.Done():
+				dr.ck.Untrack(e)
+				logger.Loggers().Debugf("end of file read: %s", f.path)
+				return false
 			}
 		}
 	}
 }
 
+// offset prefers the position read so far over the checkpoint, which lags
+// behind until the outputs acknowledge. The checkpoint kept by path by the
+// previous versions is moved to the identity of the file.
+func (dr *DirReader) offset(f *dirFile) (uint64, bool, error) {
+	return checkpointOffset(dr.ck, dr.positions, f.key, fmt.Sprintf(recordpointDirLogTemplate, f.path))
+}
+
 func (dr *DirReader) Reading() bool {
 	return atomic.LoadInt32(&dr.readFlag) == 1
 }
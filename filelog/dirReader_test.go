@@ -0,0 +1,182 @@
+package filelog
+
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestDirReader(dir string, ck *record.RecordPoint, queue chan *event.Event) *DirReader {
+	mr := metrics.NewMetricRegstry()
+	mr.RegisterMetric(metrics.NewMeter("test-directoryread-rate"))
+	mr.RegisterMetric(metrics.NewCounter("test-directory-filenum"))
+	mr.RegisterMetric(metrics.NewCounter("test-record-total"))
+	return CreateDirReader("test", dir, "", nil, nil, nil, ck, queue, mr)
+}
+
+// readAll runs one read of r and acknowledges the events it sent.
+func readAll(r FileReader, queue chan *event.Event) []string {
+	r.Read()
+	lines := make([]string, 0)
+	for {
+		select {
+		case e := <-queue:
+			lines = append(lines, e.Message)
+			e.Ack()
+		default:
+			return lines
+		}
+	}
+}
+
+func appendFile(t *testing.T, path string, content string, mtime time.Time) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkLines(t *testing.T, step string, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: read %q, want %q", step, got, want)
+	}
+}
+
+func TestDirReaderRotation(t *testing.T) {
+	ck, tmp := newTestCheckpoint(t)
+	dir := filepath.Join(tmp, "logs")
+	os.Mkdir(dir, 0755)
+	active := filepath.Join(dir, "app.log")
+	now := time.Now()
+	queue := make(chan *event.Event, 100)
+
+	r := newTestDirReader(dir, ck, queue)
+	appendFile(t, active, "a1\na2\n", now.Add(-time.Minute))
+	checkLines(t, "first read", readAll(r, queue), "a1", "a2")
+
+	//logrotate：重命名后旧文件还会写入几行，再创建新的app.log
+	rotated := active + ".1"
+	if err := os.Rename(active, rotated); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, rotated, "a3\n", now.Add(-time.Second))
+	appendFile(t, active, "b1\n", now.Add(-time.Second))
+	checkLines(t, "after rotation", readAll(r, queue), "a3", "b1")
+	checkLines(t, "nothing new", readAll(r, queue))
+
+	restarted := newTestDirReader(dir, ck, queue)
+	checkLines(t, "after restart", readAll(restarted, queue))
+	appendFile(t, active, "b2\n", now)
+	checkLines(t, "append after restart", readAll(restarted, queue), "b2")
+
+	id, err := ck.GetValue(fmt.Sprintf(recordpointDirPathTemplate, rotated))
+	if err != nil || id == "" {
+		t.Fatalf("no identity of %s: %v", rotated, err)
+	}
+	done, err := ck.GetValue(fmt.Sprintf(recordpointDoneTemplate, id))
+	if err != nil || done == "" {
+		t.Fatalf("rotated file not marked done: %v", err)
+	}
+
+	//删除轮转的文件后清除它的记录
+	os.Remove(rotated)
+	checkLines(t, "after remove", readAll(restarted, queue))
+	if v, _ := ck.GetValue(fmt.Sprintf(recordpointDoneTemplate, id)); v != "" {
+		t.Error("done marker of the removed file kept")
+	}
+	if v, _ := ck.GetValue(fmt.Sprintf(recordpointDirPathTemplate, rotated)); v != "" {
+		t.Error("identity of the removed path kept")
+	}
+}
+
+func TestDirReaderActiveFileAtSameTime(t *testing.T) {
+	ck, tmp := newTestCheckpoint(t)
+	dir := filepath.Join(tmp, "logs")
+	os.Mkdir(dir, 0755)
+	active := filepath.Join(dir, "app.log")
+	mtime := time.Now().Truncate(time.Second)
+	queue := make(chan *event.Event, 100)
+	appendFile(t, active+".1", "old\n", mtime)
+	appendFile(t, active, "new\n", mtime)
+
+	r := newTestDirReader(dir, ck, queue)
+	checkLines(t, "first read", readAll(r, queue), "old", "new")
+	appendFile(t, active, "more\n", mtime)
+	checkLines(t, "append to the active file", readAll(r, queue), "more")
+}
+
+func TestDirReaderMigratesPathCheckpoint(t *testing.T) {
+	ck, tmp := newTestCheckpoint(t)
+	path := filepath.Join(tmp, "app.log")
+	appendFile(t, path, "l1\nl2\nl3\n", time.Now())
+	legacyKey := fmt.Sprintf(recordpointDirLogTemplate, path)
+	ck.SetCheckpoint(legacyKey, 6)
+
+	queue := make(chan *event.Event, 100)
+	r := newTestDirReader(tmp, ck, queue)
+	checkLines(t, "read", readAll(r, queue), "l3")
+	if v, _ := ck.GetValue(legacyKey); v != "" {
+		t.Errorf("path keyed checkpoint kept: %s", v)
+	}
+	c, ok, err := FindFileCheckpoint(ck, path)
+	if err != nil || !ok || c.Type != FileCheckpointDir || c.Offset != 9 {
+		t.Errorf("checkpoint of %s: %+v, %v, %v", path, c, ok, err)
+	}
+}
+
+func TestOlderFile(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		a, b  string
+		ta    time.Time
+		tb    time.Time
+		older bool
+	}{
+		{"app.log", "app.log.1", now, now.Add(-time.Second), false},
+		{"app.log.1", "app.log", now, now, true},
+		{"app.log", "app.log.1", now, now, false},
+		{"app.log-20240101", "app.log", now, now, true},
+		{"a-2024-01-01.log", "a-2024-01-02.log", now, now, true},
+		{"a-2024-01-02.log", "a-2024-01-01.log", now.Add(-time.Second), now, true},
+		{"app.log", "app.log", now, now, false},
+	}
+	for _, tt := range tests {
+		got := olderFile(testFileInfo{tt.a, tt.ta}, testFileInfo{tt.b, tt.tb})
+		if got != tt.older {
+			t.Errorf("olderFile(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.older)
+		}
+	}
+}
+
+type testFileInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi testFileInfo) Name() string       { return fi.name }
+func (fi testFileInfo) Size() int64        { return 0 }
+func (fi testFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi testFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi testFileInfo) IsDir() bool        { return false }
+func (fi testFileInfo) Sys() interface{}   { return nil }
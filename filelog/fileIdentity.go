@@ -0,0 +1,56 @@
+package filelog
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const fingerprintSize = 1024
+
+// fileIdentity survives renames, it is the device and inode on unix and the
+// volume serial number and file index on windows.
+type fileIdentity struct {
+	device uint64
+	inode  uint64
+}
+
+func (id fileIdentity) String() string {
+	return fmt.Sprintf("%d-%d", id.device, id.inode)
+}
+
+// fingerprint hashes the first bytes of a file so a reused inode is not taken
+// for the file that owned it before, the result is "<length>:<sha1>".
+func fingerprint(file *os.File, size int64) (string, error) {
+	if size > fingerprintSize {
+		size = fingerprintSize
+	}
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	sum := sha1.Sum(buf[:n])
+	return strconv.Itoa(n) + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// matchFingerprint checks that file still starts with the bytes fp was taken from.
+func matchFingerprint(file *os.File, fp string) bool {
+	i := strings.Index(fp, ":")
+	if i < 0 {
+		return false
+	}
+	n, err := strconv.ParseInt(fp[:i], 10, 64)
+	if err != nil {
+		return false
+	}
+	current, err := fingerprint(file, n)
+	if err != nil {
+		return false
+	}
+	return current == fp
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package filelog
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func getFileIdentity(file *os.File) (fileIdentity, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return fileIdentity{}, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileIdentity{}, errors.New("file stat is not available")
+	}
+	return fileIdentity{device: uint64(stat.Dev), inode: uint64(stat.Ino)}, nil
+}
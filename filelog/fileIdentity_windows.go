@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package filelog
+
+import (
+	"os"
+	"syscall"
+)
+
+func getFileIdentity(file *os.File) (fileIdentity, error) {
+	var info syscall.ByHandleFileInformation
+	err := syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), &info)
+	if err != nil {
+		return fileIdentity{}, err
+	}
+	return fileIdentity{
+		device: uint64(info.VolumeSerialNumber),
+		inode:  uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow),
+	}, nil
+}
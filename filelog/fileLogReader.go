@@ -1,21 +1,28 @@
 package filelog
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
 	"github.com/lucky-abc/cleat/record"
-	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/simplifiedchinese"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 )
 
-const recordpointFileLogTemplate = "filelog-%s"
+const (
+	recordpointFileLogTemplate = "filelog-%s"
+	recordpointFileIDTemplate  = "filelog-id-%s"
+	recordpointFingerprintTmpl = "filelog-fp-%s"
+	recordpointPathIDTemplate  = "filelog-path-%s"
+	recordpointDoneTemplate    = "filelog-done-%s"
+)
 
 type FileReader interface {
 	Read()
@@ -23,47 +30,43 @@ type FileReader interface {
 	Reading() bool
 }
 
-func NewMessageDecoder(charset string) (decoder *encoding.Decoder) {
-	cset := strings.ToLower(charset)
-	switch cset {
-	case "gbk":
-		decoder = simplifiedchinese.GBK.NewDecoder()
-	case "gb1830":
-		decoder = simplifiedchinese.GB18030.NewDecoder()
-	case "gb2312":
-		decoder = simplifiedchinese.HZGB2312.NewDecoder()
-	default:
-		decoder = encoding.Nop.NewDecoder()
-	}
-	return
-}
-
+// FileLogReader follows one file across rotations, checkpoints are keyed by
+// the identity of the file instead of its path.
 type FileLogReader struct {
 	filePath         string
 	ck               *record.RecordPoint
-	queue            chan string
+	queue            chan *event.Event
 	cancelContext    context.Context
 	cancelFun        func()
-	decoder          *encoding.Decoder
+	charset          string
 	readFlag         int32 //0:读取未执行，1：正在读取
+	positions        map[string]uint64
+	multiline        *MultilineConfig
+	start            *startPolicy
+	filtering        map[string]bool
+	completed        map[string]bool
 	readMeter        *metrics.Meter
 	recorTotalMetric *metrics.Counter
 }
 
-func CreateFileLogReader(path string, charset string, ck *record.RecordPoint, queue chan string, metricRegistry *metrics.MetricRegistry) *FileLogReader {
+func CreateFileLogReader(tunnelName string, path string, charset string, multiline *MultilineConfig, start *startPolicy, ck *record.RecordPoint, queue chan *event.Event, metricRegistry *metrics.MetricRegistry) *FileLogReader {
 	r := &FileLogReader{
-		filePath: path,
-		ck:       ck,
-		queue:    queue,
+		filePath:  path,
+		ck:        ck,
+		queue:     queue,
+		positions: make(map[string]uint64),
+		multiline: multiline,
+		start:     start,
+		filtering: make(map[string]bool),
+		completed: make(map[string]bool),
 	}
 	context, cancelf := context.WithCancel(context.Background())
 	r.cancelContext = context
 	r.cancelFun = cancelf
-	decoder := NewMessageDecoder(charset)
-	r.decoder = decoder
+	r.charset = charset
 
-	r.readMeter = metricRegistry.GetMeter("fileread-rate")
-	r.recorTotalMetric = metricRegistry.GetCounter("filelog-record-total")
+	r.readMeter = metricRegistry.GetMeter(tunnelName + "-fileread-rate")
+	r.recorTotalMetric = metricRegistry.GetCounter(tunnelName + "-record-total")
 	return r
 }
 
@@ -71,52 +74,302 @@ func (fr *FileLogReader) Read() {
 	atomic.StoreInt32(&fr.readFlag, 1)
 	defer func() {
 		atomic.StoreInt32(&fr.readFlag, 0)
+		fr.start = fr.start.forNewFiles()
 	}()
 	file, err := os.Open(fr.filePath)
 	if err != nil {
-		logger.Loggers().Errorf("open file error: %s,%v", file.Name(), err)
+		if os.IsNotExist(err) {
+			logger.Loggers().Debugf("file is not exist, wait for it to be created: %s", fr.filePath)
+			return
+		}
+		logger.Loggers().Errorf("open file error: %s,%v", fr.filePath, err)
 		return
 	}
 	defer file.Close()
-	offset, err := fr.ck.GetCheckpoint(fmt.Sprintf(recordpointFileLogTemplate, file.Name()))
+	id, err := getFileIdentity(file)
 	if err != nil {
-		logger.Loggers().Errorf("get file recordpoint error： %s", file.Name())
+		logger.Loggers().Errorf("get file identity error: %s,%v", fr.filePath, err)
 		return
 	}
-	if offset > 0 {
-		file.Seek(int64(offset), 0)
+	pathKey := fmt.Sprintf(recordpointPathIDTemplate, fr.filePath)
+	lastID, err := fr.ck.GetValue(pathKey)
+	if err != nil {
+		logger.Loggers().Errorf("get file identity recordpoint error: %s,%v", fr.filePath, err)
+		return
 	}
-	reader := bufio.NewReader(file)
+	if lastID != "" && lastID != id.String() {
+		logger.Loggers().Infof("file rotated: %s, finish reading the previous file first", fr.filePath)
+		if !fr.readRotated(lastID) {
+			return
+		}
+	}
+	if lastID != id.String() {
+		fr.ck.SetValue(pathKey, id.String())
+	}
+	c, err := detectCompression(file)
+	if err != nil {
+		logger.Loggers().Errorf("read file error: %s,%v", fr.filePath, err)
+		return
+	}
+	if c != nil {
+		fr.readCompressed(file, id, c)
+		return
+	}
+	offset, err := fr.startOffset(file, id)
+	if err != nil {
+		logger.Loggers().Errorf("get file recordpoint error： %s,%v", fr.filePath, err)
+		return
+	}
+	fr.readFile(file, id, offset, false)
+}
+
+// readRotated reads the file that used to live at the path to its end, it is
+// searched by identity in the same directory. It returns false if reading was cancelled.
+func (fr *FileLogReader) readRotated(lastID string) bool {
+	idKey := fmt.Sprintf(recordpointFileIDTemplate, lastID)
+	defer func() {
+		if fr.cancelContext.Err() == nil {
+			delete(fr.positions, idKey)
+			fr.ck.FinishCheckpoint(idKey)
+			fr.ck.DelCheckpoint(fmt.Sprintf(recordpointFingerprintTmpl, lastID))
+		}
+	}()
+	dir := filepath.Dir(fr.filePath)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logger.Loggers().Warnf("list rotated file directory error: %s,%v", dir, err)
+		return true
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		rotated, err := os.Open(filepath.Join(dir, info.Name()))
+		if err != nil {
+			continue
+		}
+		id, err := getFileIdentity(rotated)
+		if err != nil || id.String() != lastID {
+			rotated.Close()
+			continue
+		}
+		logger.Loggers().Infof("read rotated file: %s", rotated.Name())
+		offset, err := fr.startOffset(rotated, id)
+		if err == nil {
+			fr.readFile(rotated, id, offset, true)
+		}
+		rotated.Close()
+		return fr.cancelContext.Err() == nil
+	}
+	logger.Loggers().Warnf("rotated file of %s is not found, the rest of it is lost", fr.filePath)
+	return true
+}
+
+// startOffset returns where to continue reading file, migrating a path keyed
+// checkpoint and falling back to 0 when the file was truncated or replaced.
+// A file without checkpoint starts where the start policy says.
+func (fr *FileLogReader) startOffset(file *os.File, id fileIdentity) (uint64, error) {
+	idKey := fmt.Sprintf(recordpointFileIDTemplate, id)
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offset, checkpointed, err := checkpointOffset(fr.ck, fr.positions, idKey, fmt.Sprintf(recordpointFileLogTemplate, fr.filePath))
+	if err != nil {
+		return 0, err
+	}
+	if offset, err = verifyOffset(fr.ck, file, info, id, offset); err != nil {
+		return 0, err
+	}
+	if !checkpointed {
+		var filter bool
+		offset, filter = fr.start.initialOffset(file.Name(), info)
+		if offset > 0 {
+			fr.ck.SetCheckpoint(idKey, offset)
+		}
+		if filter {
+			fr.filtering[idKey] = true
+		}
+	}
+	return offset, nil
+}
+
+// checkpointOffset returns the position read so far under idKey, or its
+// checkpoint, a checkpoint stored by path under legacyKey is moved to idKey.
+// checkpointed is false for a file never read.
+func checkpointOffset(ck *record.RecordPoint, positions map[string]uint64, idKey string, legacyKey string) (offset uint64, checkpointed bool, err error) {
+	if offset, ok := positions[idKey]; ok {
+		return offset, true, nil
+	}
+	if offset, err = ck.GetCheckpoint(idKey); err != nil || offset > 0 {
+		return offset, offset > 0, err
+	}
+	if offset, err = ck.GetCheckpoint(legacyKey); err != nil || offset == 0 {
+		return 0, false, err
+	}
+	logger.Loggers().Infof("migrate file recordpoint: %s -> %s", legacyKey, idKey)
+	ck.SetCheckpoint(idKey, offset)
+	ck.DelCheckpoint(legacyKey)
+	return offset, true, nil
+}
+
+// verifyOffset falls back to 0 when the plain file with identity id was
+// truncated or its identity reused by another file, and keeps its fingerprint.
+func verifyOffset(ck *record.RecordPoint, file *os.File, info os.FileInfo, id fileIdentity, offset uint64) (uint64, error) {
+	fpKey := fmt.Sprintf(recordpointFingerprintTmpl, id)
+	fp, err := ck.GetValue(fpKey)
+	if err != nil {
+		return 0, err
+	}
+	replaced := fp != "" && !matchFingerprint(file, fp)
+	if offset > 0 && replaced {
+		logger.Loggers().Infof("file content replaced, read from the beginning: %s", file.Name())
+		offset = 0
+	}
+	if uint64(info.Size()) < offset {
+		logger.Loggers().Infof("file truncated, read from the beginning: %s", file.Name())
+		offset = 0
+	}
+	if replaced || !strings.HasPrefix(fp, strconv.Itoa(fingerprintSize)+":") {
+		newFp, err := fingerprint(file, info.Size())
+		if err == nil && newFp != fp {
+			ck.SetValue(fpKey, newFp)
+		}
+	}
+	return offset, nil
+}
+
+// readCompressed reads a compressed file once, it is not written anymore.
+// Its checkpoint is the offset in the uncompressed content.
+func (fr *FileLogReader) readCompressed(file *os.File, id fileIdentity, c *compression) {
+	idKey := fmt.Sprintf(recordpointFileIDTemplate, id)
+	if fr.completed[idKey] {
+		return
+	}
+	done, err := fileDone(fr.ck, file, id)
+	if err != nil {
+		logger.Loggers().Errorf("get file recordpoint error： %s,%v", fr.filePath, err)
+		return
+	}
+	if done {
+		logger.Loggers().Debugf("compressed file was read: %s", fr.filePath)
+		fr.completed[idKey] = true
+		return
+	}
+	offset, known := fr.positions[idKey]
+	if !known {
+		if offset, err = fr.ck.GetCheckpoint(idKey); err != nil {
+			logger.Loggers().Errorf("get file recordpoint error： %s,%v", fr.filePath, err)
+			return
+		}
+	}
+	if !known && offset == 0 {
+		info, err := file.Stat()
+		if err != nil {
+			logger.Loggers().Errorf("get file info error: %s,%v", fr.filePath, err)
+			return
+		}
+		start, filter := fr.start.initialOffset(fr.filePath, info)
+		if start > 0 {
+			fr.complete(file, id, idKey)
+			return
+		}
+		if filter {
+			fr.filtering[idKey] = true
+		}
+	}
+	decoder, err := fr.lineDecoder(file, c)
+	if err != nil {
+		return
+	}
+	r, err := openDecompressed(file, c, offset)
+	if err != nil {
+		logger.Loggers().Errorf("read compressed file error: %s,%v", fr.filePath, err)
+		return
+	}
+	defer r.Close()
+	logger.Loggers().Infof("read %s compressed file: %s", c.name, fr.filePath)
+	if fr.readLines(r, decoder, file.Name(), idKey, offset, true) {
+		fr.complete(file, id, idKey)
+	}
+}
+
+func (fr *FileLogReader) complete(file *os.File, id fileIdentity, idKey string) {
+	delete(fr.positions, idKey)
+	fr.completed[idKey] = true
+	if err := completeFile(fr.ck, file, id, idKey); err != nil {
+		logger.Loggers().Errorf("complete compressed file error: %s,%v", fr.filePath, err)
+	}
+}
+
+// readFile sends the lines of file from offset on, final is set for a
+// rotated file which will not be written anymore.
+func (fr *FileLogReader) readFile(file *os.File, id fileIdentity, offset uint64, final bool) {
+	idKey := fmt.Sprintf(recordpointFileIDTemplate, id)
+	decoder, err := fr.lineDecoder(file, nil)
+	if err != nil {
+		return
+	}
+	if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+		logger.Loggers().Errorf("seek file error：%s,%v", file.Name(), err)
+		return
+	}
+	fr.readLines(file, decoder, file.Name(), idKey, offset, final)
+}
+
+// lineDecoder picks the charset of file by the configured one and its content.
+func (fr *FileLogReader) lineDecoder(file *os.File, c *compression) (*lineDecoder, error) {
+	head, err := readHead(file, c)
+	if err == nil {
+		var decoder *lineDecoder
+		if decoder, err = newLineDecoder(fr.charset, head); err == nil {
+			return decoder, nil
+		}
+	}
+	logger.Loggers().Errorf("get file charset error: %s,%v", file.Name(), err)
+	return nil, err
+}
+
+// readLines sends the lines of r, the content of the file name from offset
+// on. It returns true when the end was reached.
+func (fr *FileLogReader) readLines(r io.Reader, decoder *lineDecoder, name string, idKey string, offset uint64, final bool) bool {
+	fr.positions[idKey] = offset
+	ml, _ := newMultiline(fr.multiline)
+	reader := newLineReader(r, offset, decoder, ml)
 	for {
-		line, err := reader.ReadBytes('\n')
+		msg, end, err := reader.next(fr.cancelContext, final)
 		if err != nil {
 			if err == io.EOF {
-				logger.Loggers().Infof("File read complete：%s", file.Name())
-				file.Close()
-				break
+				logger.Loggers().Debugf("File read complete：%s", name)
+				saveSkipped(fr.ck, fr.filtering, fr.positions, idKey)
+				return true
+			}
+			if fr.cancelContext.Err() == nil {
+				logger.Loggers().Errorf("file read error：%s,%v", name, err)
 			}
-			logger.Loggers().Errorf("file read error：%s,%v", file.Name(), err)
+			return false
 		}
-		offset += uint64(len(line))
-		toline, err := fr.decoder.Bytes(line[:len(line)-1])
-		if err != nil {
-			logger.Loggers().Warnf("character encoding conversion error：%v", err)
+		if fr.start.skip(fr.filtering, idKey, msg) {
+			fr.positions[idKey] = end
 			continue
 		}
-		msg := string(toline)
-		//logger.Loggers().Debug(msg)
-		//logger.Loggers().Debug(offset)
+		e := event.NewEvent(fr.filePath, msg)
+		e.SetField("file", name)
+		e.SetField("offset", end)
+		e.Checkpoint = event.Checkpoint{Key: idKey, Offset: end}
+		fr.ck.Track(e)
 	Lbl:
 		for {
 			select {
-			case fr.queue <- msg:
+			case fr.queue <- e:
+				fr.positions[idKey] = end
 				fr.readMeter.Update(1)
 				fr.recorTotalMetric.Incr(1)
-				fr.ck.SetCheckpoint(fmt.Sprintf(recordpointFileLogTemplate, file.Name()), offset)
 				break Lbl
 			case <-fr.cancelContext.Done():
-				logger.Loggers().Debugf("end of file read: %s", file.Name())
-				return
+				fr.ck.Untrack(e)
+				logger.Loggers().Debugf("end of file read: %s", name)
+				return false
 			}
 		}
 	}
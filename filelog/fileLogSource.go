@@ -1,94 +1,388 @@
 package filelog
 
 import (
+	"context"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
 	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
 	"github.com/lucky-abc/cleat/record"
+	"github.com/lucky-abc/cleat/source"
+	"github.com/pkg/errors"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
+const defaultScanInterval = 20 * time.Second
+
+// PathConfig is one entry of files.paths, Path is a file, a directory or a
+// glob pattern where "**" matches any number of directories. StartPosition
+// tells where the files without checkpoint are read from: the beginning, the
+// end, or the first line not older than StartTime, and files not modified
+// for IgnoreOlder are only read from their end.
+type PathConfig struct {
+	Path          string           `mapstructure:"path"`
+	Charset       string           `mapstructure:"charset"`
+	Include       []string         `mapstructure:"include"`
+	Exclude       []string         `mapstructure:"exclude"`
+	Recursive     bool             `mapstructure:"recursive"`
+	Multiline     *MultilineConfig `mapstructure:"multiline"`
+	StartPosition string           `mapstructure:"startPosition"`
+	StartTime     string           `mapstructure:"startTime"`
+	Timestamp     *TimestampConfig `mapstructure:"timestamp"`
+	IgnoreOlder   time.Duration    `mapstructure:"ignoreOlder"`
+}
+
+// FilesConfig is the files source section, Watch enables the fsnotify tail
+// mode and ScanInterval is the period of the fallback scan, which also picks
+// up files newly matching a pattern.
+type FilesConfig struct {
+	Paths        []PathConfig  `mapstructure:"paths"`
+	Watch        bool          `mapstructure:"watch"`
+	ScanInterval time.Duration `mapstructure:"scanInterval"`
+}
+
+// watchedReader wakes its reader whenever the watcher or the ticker signals,
+// wake has a buffer of one so signals arriving during a read are not lost.
+// done is closed when the goroutine running the reader returns.
+type watchedReader struct {
+	reader FileReader
+	path   string
+	isDir  bool
+	conf   PathConfig
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func (wr *watchedReader) signal() {
+	select {
+	case wr.wake <- struct{}{}:
+	default:
+	}
+}
+
 type FileLogSource struct {
-	logChan        chan string
+	tunnelName     string
+	confMutex      sync.RWMutex
+	conf           *FilesConfig
+	logChan        chan *event.Event
 	ck             *record.RecordPoint
-	fileReaders    []FileReader
+	readersMutex   sync.Mutex
+	fileReaders    map[string]*watchedReader
 	timeTicker     *time.Ticker
+	watcher        *fsnotify.Watcher
+	watchedDirs    map[string]bool
+	applyStart     bool
+	scanWake       chan struct{}
+	cancelContext  context.Context
+	cancelFun      func()
+	waitGroup      sync.WaitGroup
 	metricRegistry *metrics.MetricRegistry
 }
 
-func NewFileLogSource(c chan string, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) *FileLogSource {
+func init() {
+	source.RegisterSource("files", func(conf interface{}) (interface{}, error) {
+		return parseFilesConfig(conf)
+	}, func(tunnelName string, conf interface{}, queue chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) (source.Source, error) {
+		return NewFileLogSource(tunnelName, conf.(*FilesConfig), queue, ck, metricRegistry), nil
+	})
+}
+
+func parseFilesConfig(conf interface{}) (*FilesConfig, error) {
+	filesConfig := &FilesConfig{
+		Watch:        true,
+		ScanInterval: defaultScanInterval,
+	}
+	if err := config.Decode(conf, filesConfig); err != nil {
+		return nil, err
+	}
+	errs := &config.Errors{}
+	if filesConfig.ScanInterval <= 0 {
+		errs.Add("scanInterval", "must be positive")
+	}
+	if len(filesConfig.Paths) == 0 {
+		errs.Add("paths", "no file path in config file")
+	}
+	for i, pathConfig := range filesConfig.Paths {
+		key := fmt.Sprintf("paths[%d]", i)
+		if strings.TrimSpace(pathConfig.Path) == "" {
+			errs.Add(key+".path", "must not be empty")
+		}
+		if _, err := newLineDecoder(pathConfig.Charset, nil); err != nil {
+			errs.Add(key+".charset", "%v", err)
+		}
+		if _, err := newMultiline(pathConfig.Multiline); err != nil {
+			errs.Add(key+".multiline", "%v", err)
+		}
+		if _, err := newStartPolicy(pathConfig); err != nil {
+			errs.Merge(key, err)
+		}
+	}
+	return filesConfig, errs.Err()
+}
+
+func NewFileLogSource(tunnelName string, conf *FilesConfig, c chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) *FileLogSource {
 	s := &FileLogSource{
+		tunnelName:     tunnelName,
+		conf:           conf,
 		logChan:        c,
 		ck:             ck,
-		fileReaders:    make([]FileReader, 0),
+		fileReaders:    make(map[string]*watchedReader),
+		watchedDirs:    make(map[string]bool),
+		applyStart:     true,
+		scanWake:       make(chan struct{}, 1),
 		metricRegistry: metricRegistry,
 	}
+	s.cancelContext, s.cancelFun = context.WithCancel(context.Background())
 	return s
 }
 
 func (s *FileLogSource) Start() {
-	paths, ok := config.Config().Get("files.paths").([]interface{})
-	if !ok {
-		logger.Loggers().Error("no file path in config file")
-		return
-	}
-	fileReadMeter := metrics.NewMeter("fileread-rate")
+	fileReadMeter := metrics.NewMeter(s.tunnelName + "-fileread-rate")
 	s.metricRegistry.RegisterMetric(fileReadMeter)
 
-	dirReadMeter := metrics.NewMeter("directoryread-rate")
-	fileNumMetric := metrics.NewCounter("directory-filenum")
-	recordNumMetric := metrics.NewCounter("filelog-record-total")
+	dirReadMeter := metrics.NewMeter(s.tunnelName + "-directoryread-rate")
+	fileNumMetric := metrics.NewCounter(s.tunnelName + "-directory-filenum")
+	recordNumMetric := metrics.NewCounter(s.tunnelName + "-record-total")
 	s.metricRegistry.RegisterMetric(recordNumMetric)
 	s.metricRegistry.RegisterMetric(dirReadMeter)
 	s.metricRegistry.RegisterMetric(fileNumMetric)
 
-	for _, pathInfo := range paths {
-		pathMap, ok := pathInfo.(map[interface{}]interface{})
-		if !ok {
-			logger.Loggers().Error("no file path in config file2")
-			return
+	if s.conf.Watch {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			logger.Loggers().Warnf("create file watcher error, fall back to scanning every %v: %v", s.conf.ScanInterval, err)
+		} else {
+			s.watcher = watcher
+			s.waitGroup.Add(1)
+			go s.watch()
+		}
+	}
+	s.scan()
+	s.timeTicker = time.NewTicker(s.conf.ScanInterval)
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+		for {
+			select {
+			case t := <-s.timeTicker.C:
+				logger.Loggers().Debugf("file reader exec duration: %v", t.Format("2006-01-02 15:04:05.000"))
+				s.scan()
+				s.readersMutex.Lock()
+				for _, wr := range s.fileReaders {
+					wr.signal()
+				}
+				s.readersMutex.Unlock()
+			case <-s.scanWake:
+				s.scan()
+			case <-s.cancelContext.Done():
+				return
+			}
 		}
-		path := pathMap["path"].(string)
-		charset := pathMap["charset"].(string)
-		finfo, err := os.Stat(path)
+	}()
+
+}
+
+// scan expands the configured paths, starts readers for new matches and
+// stops the readers whose file no longer matches. Only the readers started by
+// the first scan after the start or a reload apply the start position of
+// their path, the files matching later are new and read from the beginning.
+func (s *FileLogSource) scan() {
+	s.confMutex.Lock()
+	paths := s.conf.Paths
+	applyStart := s.applyStart
+	s.applyStart = false
+	s.confMutex.Unlock()
+	targets := make(map[string]fileTarget)
+	for _, pathConfig := range paths {
+		found, dirs, err := expandPath(pathConfig)
 		if err != nil {
 			if os.IsNotExist(err) {
-				logger.Loggers().Errorf("the file is not exist: %v", finfo.Name())
+				logger.Loggers().Debugf("the file is not exist: %v", pathConfig.Path)
 				continue
 			}
-			logger.Loggers().Errorf("get file info error: %v", finfo.Name())
+			logger.Loggers().Errorf("get file info error: %v,%v", pathConfig.Path, err)
+			continue
+		}
+		for _, t := range found {
+			if _, ok := targets[t.path]; !ok {
+				targets[t.path] = t
+			}
+		}
+		s.watchDirs(dirs)
+	}
+	s.readersMutex.Lock()
+	defer s.readersMutex.Unlock()
+	for path, wr := range s.fileReaders {
+		t, ok := targets[path]
+		if !ok {
+			logger.Loggers().Infof("stop reading file no longer matched: %s", path)
+		} else if !reflect.DeepEqual(t.conf, wr.conf) {
+			logger.Loggers().Infof("restart reading file with the new config: %s", path)
+		} else {
+			continue
+		}
+		close(wr.stop)
+		wr.reader.Close()
+		//等待旧的读取结束，避免与新的reader同时读取并提交同一个文件的checkpoint
+		<-wr.done
+		delete(s.fileReaders, path)
+	}
+	for path, t := range targets {
+		if _, ok := s.fileReaders[path]; ok {
 			continue
 		}
-		var fileReader FileReader
-		if finfo.IsDir() {
-			fileReader = CreateDirReader(path, charset, s.ck, s.logChan, s.metricRegistry)
+		logger.Loggers().Infof("start reading file: %s", path)
+		wr := &watchedReader{
+			path:  path,
+			isDir: t.isDir,
+			conf:  t.conf,
+			wake:  make(chan struct{}, 1),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		start, _ := newStartPolicy(t.conf)
+		if !applyStart {
+			start = start.forNewFiles()
+		}
+		if wr.isDir {
+			wr.reader = CreateDirReader(s.tunnelName, path, t.conf.Charset, newFileFilter(t.conf), t.conf.Multiline, start, s.ck, s.logChan, s.metricRegistry)
 		} else {
-			fileReader = CreateFileLogReader(path, charset, s.ck, s.logChan, s.metricRegistry)
+			wr.reader = CreateFileLogReader(s.tunnelName, path, t.conf.Charset, t.conf.Multiline, start, s.ck, s.logChan, s.metricRegistry)
 		}
-		s.fileReaders = append(s.fileReaders, fileReader)
+		s.fileReaders[path] = wr
+		s.waitGroup.Add(1)
+		go s.runReader(wr)
+		wr.signal()
 	}
-	s.timeTicker = time.NewTicker(20 * time.Second)
-	go func() {
-		for t := range s.timeTicker.C {
-			logger.Loggers().Debugf("file reader exec duration: %v", t.Format("2006-01-02 15:04:05.000"))
-			for _, reader := range s.fileReaders {
-				if !reader.Reading() {
-					go reader.Read()
+}
+
+func (s *FileLogSource) runReader(wr *watchedReader) {
+	defer s.waitGroup.Done()
+	defer close(wr.done)
+	for {
+		select {
+		case <-wr.wake:
+			if s.cancelContext.Err() != nil {
+				return
+			}
+			select {
+			case <-wr.stop:
+				return
+			default:
+			}
+			wr.reader.Read()
+		case <-wr.stop:
+			return
+		case <-s.cancelContext.Done():
+			return
+		}
+	}
+}
+
+func (s *FileLogSource) watchDirs(dirs []string) {
+	if s.watcher == nil {
+		return
+	}
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if s.watchedDirs[dir] {
+			continue
+		}
+		if err := s.watcher.Add(dir); err != nil {
+			logger.Loggers().Warnf("watch directory error: %s,%v", dir, err)
+			continue
+		}
+		s.watchedDirs[dir] = true
+	}
+}
+
+// watch wakes the readers of changed files, a change to any other file may be
+// a new match so it triggers a scan.
+func (s *FileLogSource) watch() {
+	defer s.waitGroup.Done()
+	for {
+		select {
+		case e, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+			if !s.notify(filepath.Clean(e.Name)) || e.Op&fsnotify.Create != 0 {
+				select {
+				case s.scanWake <- struct{}{}:
+				default:
 				}
 			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Loggers().Warnf("file watcher error: %v", err)
+		case <-s.cancelContext.Done():
+			return
 		}
-	}()
+	}
+}
 
+func (s *FileLogSource) notify(name string) bool {
+	s.readersMutex.Lock()
+	defer s.readersMutex.Unlock()
+	notified := false
+	for _, wr := range s.fileReaders {
+		if wr.isDir && filepath.Dir(name) == wr.path || !wr.isDir && name == wr.path {
+			wr.signal()
+			notified = true
+		}
+	}
+	return notified
 }
 
 func (s *FileLogSource) Process() {
 }
 
+// Reload applies new paths with a scan, only the readers of the paths which
+// changed are restarted.
+func (s *FileLogSource) Reload(conf interface{}) (bool, error) {
+	filesConfig, ok := conf.(*FilesConfig)
+	if !ok {
+		return false, errors.Errorf("unexpected files config: %T", conf)
+	}
+	s.confMutex.Lock()
+	if filesConfig.Watch != s.conf.Watch || filesConfig.ScanInterval != s.conf.ScanInterval {
+		s.confMutex.Unlock()
+		return false, nil
+	}
+	s.conf = filesConfig
+	s.applyStart = true
+	s.confMutex.Unlock()
+	select {
+	case s.scanWake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
 func (s *FileLogSource) Stop() {
 	s.timeTicker.Stop()
-	for _, reader := range s.fileReaders {
-		reader.Close()
+	s.cancelFun()
+	s.readersMutex.Lock()
+	for _, wr := range s.fileReaders {
+		wr.reader.Close()
+	}
+	s.readersMutex.Unlock()
+	s.waitGroup.Wait()
+	if s.watcher != nil {
+		s.watcher.Close()
 	}
 	logger.Loggers().Debug("closed file source")
 }
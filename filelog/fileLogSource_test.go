@@ -0,0 +1,72 @@
+package filelog
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCheckpoint opens a checkpoint store in a temporary directory removed
+// with the test.
+func newTestCheckpoint(t *testing.T) (*record.RecordPoint, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cleat-filelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ck, err := record.NewCheckpoint(filepath.Join(dir, "checkpoint"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ck.Close()
+		os.RemoveAll(dir)
+	})
+	return ck, dir
+}
+
+func TestReloadWaitsForTheReplacedReader(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("line\n", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	queue := make(chan *event.Event, 1)
+	conf := &FilesConfig{Paths: []PathConfig{{Path: path}}, ScanInterval: time.Hour}
+	s := NewFileLogSource("test", conf, queue, ck, metrics.NewMetricRegstry())
+	s.Start()
+	defer s.Stop()
+	//队列已满，reader阻塞在发送上
+	select {
+	case <-queue:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing read")
+	}
+	s.readersMutex.Lock()
+	old := s.fileReaders[path]
+	s.readersMutex.Unlock()
+
+	reloaded, err := s.Reload(&FilesConfig{Paths: []PathConfig{{Path: path, Charset: "utf-8"}}, ScanInterval: time.Hour})
+	if err != nil || !reloaded {
+		t.Fatalf("reload: %v, %v", reloaded, err)
+	}
+	s.scan()
+	select {
+	case <-old.done:
+	default:
+		t.Fatal("the replacement reader started before the old one returned")
+	}
+	s.readersMutex.Lock()
+	current := s.fileReaders[path]
+	s.readersMutex.Unlock()
+	if current == old || current.conf.Charset != "utf-8" {
+		t.Fatal("reader not replaced")
+	}
+}
@@ -0,0 +1,105 @@
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/lucky-abc/cleat/logger"
+	"io"
+	"time"
+)
+
+const multilinePollInterval = 200 * time.Millisecond
+
+// lineReader turns the content of a file into messages, decoding the charset
+// and merging multiline events. A trailing line without newline is kept until
+// it is completed, or sent as it is when the file will not grow anymore.
+type lineReader struct {
+	reader    *bufio.Reader
+	decoder   *lineDecoder
+	multiline *multiline
+	partial   []byte
+	offset    uint64
+}
+
+func newLineReader(r io.Reader, offset uint64, decoder *lineDecoder, ml *multiline) *lineReader {
+	return &lineReader{
+		reader:    bufio.NewReader(r),
+		decoder:   decoder,
+		multiline: ml,
+		offset:    offset,
+	}
+}
+
+// readLine returns the next line with its newline, a UTF-16 newline only
+// counts at the start of a 2 byte unit. With final the last line is returned
+// without newline.
+func (lr *lineReader) readLine(final bool) ([]byte, error) {
+	newline := lr.decoder.newline
+	data := lr.partial
+	lr.partial = nil
+	for {
+		chunk, err := lr.reader.ReadBytes(newline[len(newline)-1])
+		data = append(data, chunk...)
+		if err != nil {
+			if err != io.EOF || len(data) == 0 {
+				return nil, err
+			}
+			if !final {
+				lr.partial = data
+				return nil, err
+			}
+			break
+		}
+		if len(data)%len(newline) == 0 && bytes.HasSuffix(data, newline) {
+			break
+		}
+	}
+	lr.offset += uint64(len(data))
+	return data, nil
+}
+
+// next returns the next message and the offset right after it. At the end of
+// the file a pending multiline event is returned once its timeout expired, or
+// at once when final is set because the file will not grow anymore.
+func (lr *lineReader) next(ctx context.Context, final bool) (string, uint64, error) {
+	for {
+		line, err := lr.readLine(final)
+		if err == io.EOF {
+			if lr.multiline == nil || !lr.multiline.pending() {
+				return "", 0, io.EOF
+			}
+			if final || lr.multiline.expired() {
+				msg, end := lr.multiline.flush()
+				return msg, end, nil
+			}
+			select {
+			case <-ctx.Done():
+				return "", 0, ctx.Err()
+			case <-time.After(multilinePollInterval):
+			}
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		content := line
+		if len(line)%len(lr.decoder.newline) == 0 && bytes.HasSuffix(line, lr.decoder.newline) {
+			content = line[:len(line)-len(lr.decoder.newline)]
+		}
+		if lr.offset == uint64(len(line)) {
+			content = bytes.TrimPrefix(content, lr.decoder.bom)
+		}
+		toline, err := lr.decoder.decode(content)
+		if err != nil {
+			logger.Loggers().Warnf("character encoding conversion error：%v", err)
+			continue
+		}
+		if lr.multiline == nil {
+			return string(toline), lr.offset, nil
+		}
+		if msg, end, ok := lr.multiline.add(string(toline), lr.offset); ok {
+			return msg, end, nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package filelog
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/lucky-abc/cleat/event"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type readMessage struct {
+	msg string
+	end uint64
+}
+
+func readMessages(t *testing.T, content string, final bool) []readMessage {
+	t.Helper()
+	decoder, err := newLineDecoder("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newLineReader(strings.NewReader(content), 0, decoder, nil)
+	messages := make([]readMessage, 0)
+	for {
+		msg, end, err := r.next(context.Background(), final)
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, readMessage{msg, end})
+	}
+}
+
+func TestLineReaderLastLineWithoutNewline(t *testing.T) {
+	content := "1 first\n2 second\n3 tail-no-newline"
+	got := readMessages(t, content, false)
+	if len(got) != 2 || got[1] != (readMessage{"2 second", 17}) {
+		t.Fatalf("growing file read %v", got)
+	}
+	got = readMessages(t, content, true)
+	if len(got) != 3 || got[2] != (readMessage{"3 tail-no-newline", uint64(len(content))}) {
+		t.Fatalf("final file read %v", got)
+	}
+}
+
+func TestCompressedFileLastLineWithoutNewline(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "app.log.1.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	w.Write([]byte("a1\na2 no newline"))
+	w.Close()
+	f.Close()
+	queue := make(chan *event.Event, 10)
+	r := newTestFileReader(path, ck, queue)
+	checkLines(t, "compressed file", readAll(r, queue), "a1", "a2 no newline")
+	checkLines(t, "read again", readAll(newTestFileReader(path, ck, queue), queue))
+}
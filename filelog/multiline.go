@@ -0,0 +1,103 @@
+package filelog
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMultilineMaxLines = 500
+	defaultMultilineTimeout  = 5 * time.Second
+)
+
+// MultilineConfig merges lines into one event. A line matching StartPattern
+// begins a new event, a line matching ContinuePattern is appended to the
+// previous one, Negate inverts whichever pattern is set.
+type MultilineConfig struct {
+	StartPattern    string        `mapstructure:"startPattern"`
+	ContinuePattern string        `mapstructure:"continuePattern"`
+	Negate          bool          `mapstructure:"negate"`
+	MaxLines        int           `mapstructure:"maxLines"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+}
+
+type multiline struct {
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	negate   bool
+	maxLines int
+	timeout  time.Duration
+	lines    []string
+	end      uint64
+	last     time.Time
+}
+
+func newMultiline(conf *MultilineConfig) (*multiline, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	m := &multiline{
+		negate:   conf.Negate,
+		maxLines: conf.MaxLines,
+		timeout:  conf.Timeout,
+	}
+	var err error
+	switch {
+	case conf.StartPattern != "":
+		m.start, err = regexp.Compile(conf.StartPattern)
+	case conf.ContinuePattern != "":
+		m.cont, err = regexp.Compile(conf.ContinuePattern)
+	default:
+		return nil, errors.New("multiline needs startPattern or continuePattern")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid multiline pattern")
+	}
+	if m.maxLines <= 0 {
+		m.maxLines = defaultMultilineMaxLines
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultMultilineTimeout
+	}
+	return m, nil
+}
+
+func (m *multiline) isStart(line string) bool {
+	if m.start != nil {
+		return m.start.MatchString(line) != m.negate
+	}
+	return m.cont.MatchString(line) == m.negate
+}
+
+// add appends line, which ends at offset end, and returns the previous event
+// when line starts a new one or the previous one is full.
+func (m *multiline) add(line string, end uint64) (string, uint64, bool) {
+	var msg string
+	var msgEnd uint64
+	var complete bool
+	if len(m.lines) > 0 && (m.isStart(line) || len(m.lines) >= m.maxLines) {
+		msg, msgEnd = m.flush()
+		complete = true
+	}
+	m.lines = append(m.lines, line)
+	m.end = end
+	m.last = time.Now()
+	return msg, msgEnd, complete
+}
+
+func (m *multiline) pending() bool {
+	return len(m.lines) > 0
+}
+
+// expired reports whether no line was added to the pending event for the flush timeout.
+func (m *multiline) expired() bool {
+	return time.Since(m.last) >= m.timeout
+}
+
+func (m *multiline) flush() (string, uint64) {
+	msg := strings.Join(m.lines, "\n")
+	m.lines = m.lines[:0]
+	return msg, m.end
+}
@@ -0,0 +1,102 @@
+package filelog
+
+import (
+	"context"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readMultiline(t *testing.T, conf *MultilineConfig, content string, final bool) []readMessage {
+	t.Helper()
+	decoder, err := newLineDecoder("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml, err := newMultiline(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newLineReader(strings.NewReader(content), 0, decoder, ml)
+	messages := make([]readMessage, 0)
+	for {
+		msg, end, err := r.next(context.Background(), final)
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, readMessage{msg, end})
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	content := "2024-01-02 error\n  at a\n  at b\n2024-01-02 ok\n  at c\n"
+	tests := []struct {
+		name  string
+		conf  MultilineConfig
+		final bool
+		want  []readMessage
+	}{
+		{"timeout flushes the last event", MultilineConfig{StartPattern: `^\d{4}-`, Timeout: 50 * time.Millisecond}, false, []readMessage{
+			{"2024-01-02 error\n  at a\n  at b", 31},
+			{"2024-01-02 ok\n  at c", uint64(len(content))},
+		}},
+		{"final flushes the last event", MultilineConfig{StartPattern: `^\d{4}-`}, true, []readMessage{
+			{"2024-01-02 error\n  at a\n  at b", 31},
+			{"2024-01-02 ok\n  at c", uint64(len(content))},
+		}},
+		{"continue pattern", MultilineConfig{ContinuePattern: `^\s`}, true, []readMessage{
+			{"2024-01-02 error\n  at a\n  at b", 31},
+			{"2024-01-02 ok\n  at c", uint64(len(content))},
+		}},
+		{"negated start pattern", MultilineConfig{StartPattern: `^\s`, Negate: true}, true, []readMessage{
+			{"2024-01-02 error\n  at a\n  at b", 31},
+			{"2024-01-02 ok\n  at c", uint64(len(content))},
+		}},
+		{"max lines", MultilineConfig{StartPattern: `^\d{4}-`, MaxLines: 2}, true, []readMessage{
+			{"2024-01-02 error\n  at a", 24},
+			{"  at b", 31},
+			{"2024-01-02 ok\n  at c", uint64(len(content))},
+		}},
+	}
+	for _, tt := range tests {
+		if got := readMultiline(t, &tt.conf, content, tt.final); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: read %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := newMultiline(&MultilineConfig{}); err == nil {
+		t.Error("multiline without pattern accepted")
+	}
+}
+
+func TestMultilineCheckpointAfterLastLine(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "app.log")
+	content := "2024-01-02 error\n  at a\n2024-01-02 ok\n  at b\n"
+	writeFile(t, path, []byte(content))
+	queue := make(chan *event.Event, 10)
+	newReader := func() *FileLogReader {
+		mr := metrics.NewMetricRegstry()
+		mr.RegisterMetric(metrics.NewMeter("test-fileread-rate"))
+		mr.RegisterMetric(metrics.NewCounter("test-record-total"))
+		conf := &MultilineConfig{StartPattern: `^\d{4}-`, Timeout: 100 * time.Millisecond}
+		return CreateFileLogReader("test", path, "", conf, nil, ck, queue, mr)
+	}
+	//最后一条在timeout后输出，checkpoint指向它最后一行之后
+	checkLines(t, "first read", readAll(newReader(), queue), "2024-01-02 error\n  at a", "2024-01-02 ok\n  at b")
+	checkpoints, err := FileCheckpoints(ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].Offset != uint64(len(content)) {
+		t.Fatalf("checkpoints %+v, want offset %d", checkpoints, len(content))
+	}
+	checkLines(t, "read again", readAll(newReader(), queue))
+}
@@ -0,0 +1,178 @@
+package filelog
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileTarget is one file or directory a reader is created for.
+type fileTarget struct {
+	path  string
+	isDir bool
+	conf  PathConfig
+}
+
+// fileFilter applies the include and exclude patterns of a path, patterns
+// without a separator match the file name, the others the whole path.
+type fileFilter struct {
+	include []string
+	exclude []string
+}
+
+func newFileFilter(conf PathConfig) *fileFilter {
+	return &fileFilter{
+		include: conf.Include,
+		exclude: conf.Exclude,
+	}
+}
+
+func (f *fileFilter) accept(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, pattern := range f.exclude {
+		if matchFilterPattern(pattern, name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matchFilterPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFilterPattern(pattern string, name string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, filepath.Base(name))
+		return ok
+	}
+	return matchPattern(pattern, filepath.ToSlash(name))
+}
+
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// matchPattern matches slash separated paths, a "**" segment matches any
+// number of directories.
+func matchPattern(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// patternBase is the longest leading directory of pattern without glob characters.
+func patternBase(pattern string) string {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	base := make([]string, 0, len(segments))
+	for _, s := range segments {
+		if hasMeta(s) {
+			break
+		}
+		base = append(base, s)
+	}
+	if len(base) == len(segments) {
+		base = base[:len(base)-1]
+	}
+	b := strings.Join(base, "/")
+	if b == "" && strings.HasPrefix(pattern, "/") {
+		b = "/"
+	}
+	if b == "" {
+		b = "."
+	}
+	return filepath.FromSlash(b)
+}
+
+// expandPath resolves a configured path into the files and directories to
+// read, along with the directories that have to be watched for new matches.
+func expandPath(conf PathConfig) (targets []fileTarget, dirs []string, err error) {
+	filter := newFileFilter(conf)
+	if !hasMeta(conf.Path) {
+		p := filepath.Clean(conf.Path)
+		info, err := os.Stat(p)
+		if err != nil {
+			// a missing file is kept so it is read as soon as it is created
+			if os.IsNotExist(err) && dirExists(filepath.Dir(p)) {
+				return []fileTarget{{path: p, conf: conf}}, []string{filepath.Dir(p)}, nil
+			}
+			return nil, nil, err
+		}
+		if !info.IsDir() {
+			return []fileTarget{{path: p, conf: conf}}, []string{filepath.Dir(p)}, nil
+		}
+		if !conf.Recursive {
+			return []fileTarget{{path: p, isDir: true, conf: conf}}, []string{p}, nil
+		}
+		err = filepath.Walk(p, func(walkPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				targets = append(targets, fileTarget{path: walkPath, isDir: true, conf: conf})
+				dirs = append(dirs, walkPath)
+			}
+			return nil
+		})
+		return targets, dirs, err
+	}
+	pattern := filepath.ToSlash(filepath.Clean(conf.Path))
+	base := patternBase(pattern)
+	// without "**" a match can not be deeper than the pattern itself
+	maxDepth := -1
+	if !strings.Contains(pattern, "**") {
+		maxDepth = strings.Count(pattern, "/") - strings.Count(filepath.ToSlash(base), "/")
+	}
+	err = filepath.Walk(base, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			rel, _ := filepath.Rel(base, walkPath)
+			if maxDepth >= 0 && rel != "." && strings.Count(filepath.ToSlash(rel), "/")+1 >= maxDepth {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, walkPath)
+			return nil
+		}
+		if matchPattern(pattern, filepath.ToSlash(walkPath)) && filter.accept(walkPath) {
+			targets = append(targets, fileTarget{path: filepath.Clean(walkPath), conf: conf})
+		}
+		return nil
+	})
+	return targets, dirs, err
+}
+
+func dirExists(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
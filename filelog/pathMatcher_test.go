@@ -0,0 +1,141 @@
+package filelog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"/var/log/*.log", "/var/log/app.log", true},
+		{"/var/log/*.log", "/var/log/app/app.log", false},
+		{"/var/log/**/*.log", "/var/log/app.log", true},
+		{"/var/log/**/*.log", "/var/log/app/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/b/c/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/b/app.txt", false},
+		{"/var/log/**", "/var/log/a/b/app.txt", true},
+		{"/var/log/**", "/var/log", true},
+		{"/var/**/app/*.log", "/var/log/app/x.log", true},
+		{"/var/**/app/*.log", "/var/log/other/x.log", false},
+		{"/var/**/**/x.log", "/var/x.log", true},
+		{"/var/log/app-?.log", "/var/log/app-1.log", true},
+		{"/var/log/[ab].log", "/var/log/c.log", false},
+		{"/var/log/[.log", "/var/log/[.log", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.name); got != tt.match {
+			t.Errorf("matchPattern(%s, %s) = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestFileFilter(t *testing.T) {
+	f := newFileFilter(PathConfig{Include: []string{"*.log", "**/audit/*"}, Exclude: []string{"*.gz", "/var/log/tmp/**"}})
+	tests := []struct {
+		name   string
+		accept bool
+	}{
+		{"/var/log/app.log", true},
+		{"/var/log/app.txt", false},
+		{"/var/log/app.log.gz", false},
+		{"/var/log/audit/events", true},
+		{"/var/log/audit/old.gz", false},
+		{"/var/log/tmp/app.log", false},
+	}
+	for _, tt := range tests {
+		if got := f.accept(tt.name); got != tt.accept {
+			t.Errorf("accept(%s) = %v, want %v", tt.name, got, tt.accept)
+		}
+	}
+	var none *fileFilter
+	if !none.accept("/var/log/app.txt") {
+		t.Error("nil filter rejected a file")
+	}
+}
+
+func TestPatternBase(t *testing.T) {
+	tests := map[string]string{
+		"/var/log/*.log":       "/var/log",
+		"/var/log/**/app.log":  "/var/log",
+		"/var/*/app/*.log":     "/var",
+		"/*.log":               "/",
+		"*.log":                ".",
+		"logs/app-?/today.log": "logs",
+	}
+	for pattern, want := range tests {
+		if got := filepath.ToSlash(patternBase(pattern)); got != want {
+			t.Errorf("patternBase(%s) = %s, want %s", pattern, got, want)
+		}
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleat-pattern")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, name := range []string{"app.log", "app.txt", "a/app.log", "a/b/app.log", "a/b/app.log.gz", "c/app.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		conf  PathConfig
+		files []string
+		dirs  []string
+	}{
+		{PathConfig{Path: filepath.Join(dir, "*.log")}, []string{"app.log"}, []string{"."}},
+		{PathConfig{Path: filepath.Join(dir, "*", "*.log")}, []string{"a/app.log"}, []string{".", "a", "c"}},
+		{PathConfig{Path: filepath.Join(dir, "**", "*.log")}, []string{"a/app.log", "a/b/app.log", "app.log"}, []string{".", "a", "a/b", "c"}},
+		{PathConfig{Path: filepath.Join(dir, "**", "*"), Exclude: []string{"*.gz", "*.txt"}}, []string{"a/app.log", "a/b/app.log", "app.log"}, []string{".", "a", "a/b", "c"}},
+		{PathConfig{Path: filepath.Join(dir, "missing.log")}, []string{"missing.log"}, []string{"."}},
+	}
+	for _, tt := range tests {
+		targets, dirs, err := expandPath(tt.conf)
+		if err != nil {
+			t.Errorf("expandPath(%s): %v", tt.conf.Path, err)
+			continue
+		}
+		files := make([]string, 0, len(targets))
+		for _, target := range targets {
+			if target.isDir {
+				t.Errorf("expandPath(%s) returned directory %s", tt.conf.Path, target.path)
+			}
+			files = append(files, relPath(t, dir, target.path))
+		}
+		watched := make([]string, 0, len(dirs))
+		for _, d := range dirs {
+			watched = append(watched, relPath(t, dir, d))
+		}
+		sort.Strings(files)
+		sort.Strings(watched)
+		if !reflect.DeepEqual(files, tt.files) {
+			t.Errorf("expandPath(%s) files %v, want %v", tt.conf.Path, files, tt.files)
+		}
+		if !reflect.DeepEqual(watched, tt.dirs) {
+			t.Errorf("expandPath(%s) dirs %v, want %v", tt.conf.Path, watched, tt.dirs)
+		}
+	}
+}
+
+func relPath(t *testing.T, base string, p string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.ToSlash(rel)
+}
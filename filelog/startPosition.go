@@ -0,0 +1,205 @@
+package filelog
+
+import (
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/logger"
+	"github.com/lucky-abc/cleat/record"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StartBeginning = "beginning"
+	StartEnd       = "end"
+	StartTime      = "time"
+)
+
+// TimestampConfig parses the time of a line, Pattern picks the timestamp out
+// of the line, its first group if it has one, and Layout is a Go time layout
+// or unix / unixMs for epoch seconds / milliseconds. Without Pattern the
+// timestamp is the start of the line as long as Layout, or its first word for
+// the epoch layouts.
+type TimestampConfig struct {
+	Pattern  string `mapstructure:"pattern"`
+	Layout   string `mapstructure:"layout"`
+	Timezone string `mapstructure:"timezone"`
+}
+
+// startPolicy decides where a file without checkpoint is read from. The start
+// position only applies to the files found when a reader starts, files
+// created later are read from the beginning, ignoreOlder applies to all.
+type startPolicy struct {
+	position    string
+	since       time.Time
+	ignoreOlder time.Duration
+	pattern     *regexp.Regexp
+	layout      string
+	location    *time.Location
+}
+
+func newStartPolicy(conf PathConfig) (*startPolicy, error) {
+	errs := &config.Errors{}
+	p := &startPolicy{
+		position:    strings.ToLower(conf.StartPosition),
+		ignoreOlder: conf.IgnoreOlder,
+		location:    time.Local,
+	}
+	if conf.IgnoreOlder < 0 {
+		errs.Add("ignoreOlder", "must not be negative")
+	}
+	switch p.position {
+	case "":
+		p.position = StartBeginning
+	case StartBeginning, StartEnd:
+	case StartTime:
+		if conf.Timestamp == nil {
+			errs.Add("timestamp", "startPosition time needs the timestamp of the lines")
+		} else {
+			p.parseTimestamp(conf.Timestamp, errs)
+		}
+		if conf.StartTime == "" {
+			errs.Add("startTime", "startPosition time needs startTime")
+		} else if errs.Len() == 0 {
+			p.parseStartTime(conf.StartTime, errs)
+		}
+		return p, errs.Err()
+	default:
+		errs.Add("startPosition", "unknown start position %q, must be beginning, end or time", conf.StartPosition)
+	}
+	if conf.StartTime != "" {
+		errs.Add("startTime", "only used with startPosition time")
+	}
+	if conf.Timestamp != nil {
+		errs.Add("timestamp", "only used with startPosition time")
+	}
+	return p, errs.Err()
+}
+
+func (p *startPolicy) parseTimestamp(conf *TimestampConfig, errs *config.Errors) {
+	var err error
+	if conf.Pattern != "" {
+		if p.pattern, err = regexp.Compile(conf.Pattern); err != nil {
+			errs.Add("timestamp.pattern", "invalid pattern: %v", err)
+		}
+	}
+	p.layout = conf.Layout
+	if p.layout == "" {
+		errs.Add("timestamp.layout", "must not be empty")
+	}
+	if conf.Timezone != "" {
+		if p.location, err = time.LoadLocation(conf.Timezone); err != nil {
+			errs.Add("timestamp.timezone", "unknown timezone: %v", err)
+		}
+	}
+}
+
+// parseStartTime accepts a duration back from now, like 24h, an RFC 3339
+// time or a time in the timestamp layout.
+func (p *startPolicy) parseStartTime(s string, errs *config.Errors) {
+	if d, err := time.ParseDuration(s); err == nil {
+		p.since = time.Now().Add(-d)
+		return
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		p.since = t
+		return
+	}
+	if t, err := p.parse(s); err == nil {
+		p.since = t
+		return
+	}
+	errs.Add("startTime", "invalid start time %q, must be a duration, an RFC 3339 time or in the timestamp layout", s)
+}
+
+func (p *startPolicy) parse(s string) (time.Time, error) {
+	switch p.layout {
+	case "unix", "unixMs":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if p.layout == "unix" {
+			return time.Unix(n, 0), nil
+		}
+		return time.Unix(0, n*int64(time.Millisecond)), nil
+	}
+	return time.ParseInLocation(p.layout, s, p.location)
+}
+
+// forNewFiles is the policy of the files showing up after the reader started.
+func (p *startPolicy) forNewFiles() *startPolicy {
+	if p == nil || p.position == StartBeginning {
+		return p
+	}
+	np := *p
+	np.position = StartBeginning
+	return &np
+}
+
+// initialOffset returns the offset to read a file without checkpoint from,
+// and whether its lines are skipped until one is not older than the start time.
+func (p *startPolicy) initialOffset(path string, info os.FileInfo) (uint64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	if p.ignoreOlder > 0 && time.Since(info.ModTime()) > p.ignoreOlder {
+		logger.Loggers().Infof("file not modified for %v, only new lines are read: %s", p.ignoreOlder, path)
+		return uint64(info.Size()), false
+	}
+	switch p.position {
+	case StartEnd:
+		logger.Loggers().Infof("start reading at the end of file: %s", path)
+		return uint64(info.Size()), false
+	case StartTime:
+		logger.Loggers().Infof("skip the lines of %s older than %v", path, p.since)
+		return 0, true
+	}
+	return 0, false
+}
+
+// older reports whether msg is from before the start time, lines without a
+// timestamp are history too until the first newer line.
+func (p *startPolicy) older(msg string) bool {
+	s := msg
+	if p.pattern != nil {
+		m := p.pattern.FindStringSubmatch(msg)
+		if m == nil {
+			return true
+		}
+		s = m[0]
+		if len(m) > 1 {
+			s = m[1]
+		}
+	} else if p.layout == "unix" || p.layout == "unixMs" {
+		s = strings.SplitN(msg, " ", 2)[0]
+	} else if len(msg) >= len(p.layout) {
+		s = msg[:len(p.layout)]
+	}
+	t, err := p.parse(s)
+	return err != nil || t.Before(p.since)
+}
+
+// skip reports whether msg read under key is dropped, the lines of a file are
+// filtered until the first one not older than the start time.
+func (p *startPolicy) skip(filtering map[string]bool, key string, msg string) bool {
+	if !filtering[key] {
+		return false
+	}
+	if p.older(msg) {
+		return true
+	}
+	delete(filtering, key)
+	return false
+}
+
+// saveSkipped stores the position after the lines skipped so far once the end
+// of the file is reached, the lines written after that are read normally.
+func saveSkipped(ck *record.RecordPoint, filtering map[string]bool, positions map[string]uint64, key string) {
+	if offset := positions[key]; filtering[key] && offset > 0 {
+		delete(filtering, key)
+		ck.SetCheckpoint(key, offset)
+	}
+}
@@ -0,0 +1,112 @@
+package filelog
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTimestamp = &TimestampConfig{Layout: "2006-01-02 15:04:05"}
+
+func newStartReader(t *testing.T, conf PathConfig, ck *record.RecordPoint, queue chan *event.Event) *FileLogReader {
+	t.Helper()
+	start, err := newStartPolicy(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := metrics.NewMetricRegstry()
+	mr.RegisterMetric(metrics.NewMeter("test-fileread-rate"))
+	mr.RegisterMetric(metrics.NewCounter("test-record-total"))
+	return CreateFileLogReader("test", conf.Path, "", nil, start, ck, queue, mr)
+}
+
+func TestNewStartPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf PathConfig
+		keys []string
+	}{
+		{"unknown position", PathConfig{StartPosition: "middle"}, []string{"startPosition"}},
+		{"negative ignoreOlder", PathConfig{IgnoreOlder: -time.Hour}, []string{"ignoreOlder"}},
+		{"time without timestamp", PathConfig{StartPosition: "time", StartTime: "1h"}, []string{"timestamp"}},
+		{"time without startTime", PathConfig{StartPosition: "time", Timestamp: testTimestamp}, []string{"startTime"}},
+		{"invalid startTime", PathConfig{StartPosition: "time", StartTime: "yesterday", Timestamp: testTimestamp}, []string{"startTime"}},
+		{"invalid timestamp", PathConfig{StartPosition: "time", StartTime: "1h", Timestamp: &TimestampConfig{Pattern: "(", Timezone: "Nowhere/City"}},
+			[]string{"timestamp.pattern", "timestamp.layout", "timestamp.timezone"}},
+		{"startTime without time", PathConfig{StartPosition: "end", StartTime: "1h", Timestamp: testTimestamp}, []string{"startTime", "timestamp"}},
+	}
+	for _, tt := range tests {
+		_, err := newStartPolicy(tt.conf)
+		if err == nil {
+			t.Errorf("%s: accepted", tt.name)
+			continue
+		}
+		for _, key := range tt.keys {
+			if !strings.Contains(err.Error(), key+":") {
+				t.Errorf("%s: %q does not report %s", tt.name, err, key)
+			}
+		}
+	}
+	for _, s := range []string{"24h", "2024-01-02T00:00:00+08:00", "2024-01-02 00:00:00"} {
+		if _, err := newStartPolicy(PathConfig{StartPosition: "TIME", StartTime: s, Timestamp: testTimestamp}); err != nil {
+			t.Errorf("startTime %q: %v", s, err)
+		}
+	}
+}
+
+func TestStartPositionEnd(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "app.log")
+	queue := make(chan *event.Event, 10)
+	appendFile(t, path, "old1\nold2\n", time.Now())
+	r := newStartReader(t, PathConfig{Path: path, StartPosition: "end"}, ck, queue)
+	checkLines(t, "first read", readAll(r, queue))
+	appendFile(t, path, "new1\n", time.Now())
+	checkLines(t, "appended", readAll(r, queue), "new1")
+
+	//reader启动后才创建的文件从头读取
+	created := filepath.Join(dir, "created.log")
+	r = newStartReader(t, PathConfig{Path: created, StartPosition: "end"}, ck, queue)
+	checkLines(t, "missing file", readAll(r, queue))
+	appendFile(t, created, "c1\nc2\n", time.Now())
+	checkLines(t, "created file", readAll(r, queue), "c1", "c2")
+}
+
+func TestStartPositionTime(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	path := filepath.Join(dir, "app.log")
+	queue := make(chan *event.Event, 10)
+	appendFile(t, path, "2024-01-01 10:00:00 old\n  continued old\n2024-01-02 08:00:00 new\n  continued new\n2024-01-01 09:00:00 late\n", time.Now())
+	conf := PathConfig{Path: path, StartPosition: "time", StartTime: "2024-01-02 00:00:00", Timestamp: testTimestamp}
+	r := newStartReader(t, conf, ck, queue)
+	checkLines(t, "first read", readAll(r, queue), "2024-01-02 08:00:00 new", "  continued new", "2024-01-01 09:00:00 late")
+	checkLines(t, "read again", readAll(newStartReader(t, conf, ck, queue), queue))
+
+	//全部是旧数据的文件记录跳过的位置，之后写入的行正常读取
+	old := filepath.Join(dir, "old.log")
+	appendFile(t, old, "2023-12-31 23:59:59 old\nno timestamp\n", time.Now())
+	conf.Path = old
+	r = newStartReader(t, conf, ck, queue)
+	checkLines(t, "old file", readAll(r, queue))
+	appendFile(t, old, "2023-12-31 23:59:59 appended\n", time.Now())
+	checkLines(t, "restarted", readAll(newStartReader(t, conf, ck, queue), queue), "2023-12-31 23:59:59 appended")
+}
+
+func TestIgnoreOlder(t *testing.T) {
+	ck, dir := newTestCheckpoint(t)
+	queue := make(chan *event.Event, 10)
+	stale := filepath.Join(dir, "stale.log")
+	appendFile(t, stale, "s1\n", time.Now().Add(-2*time.Hour))
+	fresh := filepath.Join(dir, "fresh.log")
+	appendFile(t, fresh, "f1\n", time.Now())
+
+	r := newStartReader(t, PathConfig{Path: stale, IgnoreOlder: time.Hour}, ck, queue)
+	checkLines(t, "stale file", readAll(r, queue))
+	appendFile(t, stale, "s2\n", time.Now())
+	checkLines(t, "stale file appended", readAll(r, queue), "s2")
+	checkLines(t, "fresh file", readAll(newStartReader(t, PathConfig{Path: fresh, IgnoreOlder: time.Hour}, ck, queue), queue), "f1")
+}
@@ -4,9 +4,13 @@ go 1.14
 
 require (
 	github.com/beevik/etree v1.1.0
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/json-iterator/go v1.1.6
+	github.com/klauspost/compress v1.11.13
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/natefinch/lumberjack v2.0.0+incompatible
 	github.com/pkg/errors v0.8.1
+	github.com/spf13/cast v1.3.0
 	github.com/spf13/viper v1.7.0
 	github.com/syndtr/goleveldb v1.0.0
 	go.uber.org/zap v1.15.0
@@ -1,8 +1,8 @@
 package logger
 
 import (
+	"github.com/lucky-abc/cleat/config"
 	"github.com/natefinch/lumberjack"
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
@@ -12,16 +12,14 @@ import (
 )
 
 var logger *zap.Logger
-var config *viper.Viper
 var logPath string
 
-func NewLogger(lpath string, c *viper.Viper) {
+func NewLogger(lpath string, conf config.LogConfig) {
 	logPath = lpath
-	config = c
-	writeSyncer := getLogWriter()
+	writeSyncer := getLogWriter(conf.LogFile)
 	encoder := getEncoder()
 	logLevel := zap.DebugLevel
-	switch strings.ToLower(config.GetString("log.logLevel")) {
+	switch strings.ToLower(conf.LogLevel) {
 	case "debug":
 		logLevel = zap.DebugLevel
 	case "info":
@@ -42,7 +40,11 @@ func NewLogger(lpath string, c *viper.Viper) {
 	logger = zap.New(core, zap.AddCaller())
 }
 
+// Logger discards everything until NewLogger is called, e.g. in tests.
 func Logger() *zap.Logger {
+	if logger == nil {
+		return zap.NewNop()
+	}
 	return logger
 }
 
@@ -58,13 +60,13 @@ func getEncoder() zapcore.Encoder {
 	return zapcore.NewConsoleEncoder(encoderConfig)
 }
 
-func getLogWriter() zapcore.WriteSyncer {
+func getLogWriter(conf config.LogFileConfig) zapcore.WriteSyncer {
 	lumberJackLogger := &lumberjack.Logger{
-		Filename:   filepath.Join(logPath, config.GetString("log.logFile.Filename")),
-		MaxSize:    config.GetInt("log.logFile.MaxSize"),
-		MaxBackups: config.GetInt("log.logFile.MaxBackups"),
-		MaxAge:     config.GetInt("log.logFile.MaxAge"),
-		Compress:   false,
+		Filename:   filepath.Join(logPath, conf.Filename),
+		MaxSize:    conf.MaxSize,
+		MaxBackups: conf.MaxBackups,
+		MaxAge:     conf.MaxAge,
+		Compress:   conf.Compress,
 	}
 	return zapcore.NewMultiWriteSyncer(zapcore.AddSync(lumberJackLogger), zapcore.AddSync(os.Stdout))
 }
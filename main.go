@@ -1,58 +1,121 @@
 package main
 
 import (
+	"fmt"
 	"github.com/lucky-abc/cleat/config"
-	"github.com/lucky-abc/cleat/filelog"
+	"github.com/lucky-abc/cleat/event"
+	_ "github.com/lucky-abc/cleat/filelog"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
 	"github.com/lucky-abc/cleat/record"
-	"github.com/lucky-abc/cleat/wineventlog"
+	"github.com/lucky-abc/cleat/tunnel"
+	_ "github.com/lucky-abc/cleat/wineventlog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"syscall"
 	"time"
 )
 
-func main() {
-	_, configPath, dataPath, logPath := getStartupPath()
-	config.InitSystemConfig("config", configPath)
-	logger.NewLogger(logPath, config.Config())
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
 
-	metricRegistry := setupMetrics()
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
 
-	ck, err := record.NewCheckpoint(filepath.Join(dataPath, "recordpoint"))
+// run collects and forwards until a termination signal, it returns the exit code.
+func run(paths startupPaths) int {
+	if err := config.InitSystemConfig(paths.configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	systemConfig, tunnelConfigs, err := loadConfig()
 	if err != nil {
-		logger.Loggers().Error("new checkpoint error:", err)
-		return
+		fmt.Fprintf(os.Stderr, "config file error:\n%v\n", err)
+		return 1
 	}
+	logger.NewLogger(paths.logDir, systemConfig.Log)
+	event.SetHostname(systemConfig.Host)
+
+	metricRegistry := setupMetrics(systemConfig.Metrics)
 
-	winlogTunnel := wineventlog.NewWindowslogTunnel(ck, metricRegistry)
-	if winlogTunnel != nil {
-		winlogTunnel.Start()
-		winlogTunnel.Transfer()
+	ck, err := record.NewCheckpoint(filepath.Join(paths.dataDir, checkpointDir))
+	if err != nil {
+		logger.Loggers().Error("new checkpoint error:", err)
+		return 1
 	}
 
-	fileTunnel := filelog.NewFilelogTunnel(ck, metricRegistry)
-	fileTunnel.Start()
-	fileTunnel.Transfer()
+	manager := tunnel.NewManager(paths.dataDir, ck, metricRegistry)
+	manager.Apply(tunnelConfigs)
 
+	reloadChan := make(chan struct{}, 1)
+	if systemConfig.WatchConfig {
+		err := config.Watch(func() {
+			select {
+			case reloadChan <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			logger.Loggers().Error("watch config error:", err)
+		}
+	}
 	signalsChan := make(chan os.Signal, 1)
-	signal.Notify(signalsChan, os.Interrupt, os.Kill)
-	signal := <-signalsChan
-	logger.Loggers().Infof("termination signal:%v", signal)
-	logger.Loggers().Info("Terminating run. Please wait...")
-	if winlogTunnel != nil {
-		winlogTunnel.Stop()
+	signal.Notify(signalsChan, os.Interrupt, os.Kill, syscall.SIGHUP)
+	for running := true; running; {
+		select {
+		case <-reloadChan:
+			logger.Loggers().Info("config file changed")
+			reload(manager)
+		case signal := <-signalsChan:
+			if signal == syscall.SIGHUP {
+				logger.Loggers().Info("reload signal received")
+				reload(manager)
+				continue
+			}
+			logger.Loggers().Infof("termination signal:%v", signal)
+			running = false
+		}
 	}
-	fileTunnel.Stop()
+	logger.Loggers().Info("Terminating run. Please wait...")
+	manager.Stop()
 	ck.Close()
 
 	logger.Loggers().Infof("it's over")
+	return 0
 }
 
-func setupMetrics() *metrics.MetricRegistry {
+// reload applies the tunnels of the config file again, a config with errors
+// is ignored and the running tunnels are left untouched.
+func reload(manager *tunnel.Manager) {
+	if err := config.Reload(); err != nil {
+		logger.Loggers().Error("reload config error:", err)
+		return
+	}
+	_, tunnelConfigs, err := loadConfig()
+	if err != nil {
+		logger.Loggers().Errorf("config file error:\n%v", err)
+		return
+	}
+	manager.Apply(tunnelConfigs)
+	logger.Loggers().Info("config reloaded")
+}
+
+// loadConfig checks the whole config file, the problems of every section are
+// returned together.
+func loadConfig() (*config.SystemConfig, []*tunnel.TunnelConfig, error) {
+	errs := &config.Errors{}
+	systemConfig, err := config.Load()
+	errs.Merge("", err)
+	tunnelConfigs, err := tunnel.ParseConfig(systemConfig)
+	errs.Merge("", err)
+	return systemConfig, tunnelConfigs, errs.Err()
+}
+
+func setupMetrics(conf config.MetricsConfig) *metrics.MetricRegistry {
 	metricRegistry := metrics.NewMetricRegstry()
 	bootTime := time.Now()
 	infoSheetMetric := metrics.NewInfoSheet("system_overview", func(infoValues *sync.Map) {
@@ -61,43 +124,28 @@ func setupMetrics() *metrics.MetricRegistry {
 	})
 	infoSheetMetric.AddInfo("OS", runtime.GOOS)
 	metricRegistry.RegisterMetric(infoSheetMetric)
-	setupMetricReport(metricRegistry)
+	metricRegistry.RegisterMetric(metrics.NewGauge("start_time_seconds", func() int64 {
+		return bootTime.Unix()
+	}))
+	setupMetricReport(metricRegistry, conf)
 	return metricRegistry
 }
 
-func setupMetricReport(metricRegistry *metrics.MetricRegistry) {
-	reportersConfig, ok := config.Config().Get("metrics.reporters").([]interface{})
-	if !ok {
+func setupMetricReport(metricRegistry *metrics.MetricRegistry, conf config.MetricsConfig) {
+	if len(conf.Reporters) == 0 {
 		logger.Loggers().Info("there are no report config")
 		return
 	}
-	for _, reporterConfig := range reportersConfig {
-		rc, ok := reporterConfig.(map[interface{}]interface{})
-		if !ok {
-			logger.Loggers().Info("there are no report config")
-			continue
+	for _, rc := range conf.Reporters {
+		if rc.Prometheus != nil {
+			prometheusReporter := metrics.NewPrometheusReporter(logger.Logger(), metricRegistry, rc.Prometheus.Listen, rc.Prometheus.Path)
+			metricRegistry.RegisterReporter(prometheusReporter)
+			prometheusReporter.Start()
 		}
-		for k1, v1 := range rc {
-			if k1.(string) == "logfile" {
-				logfileConfig := v1.(map[interface{}]interface{})
-				if !ok {
-					logger.Loggers().Info("there are no report config")
-					break
-				}
-				var interval = ""
-				var level = ""
-				for k2, v2 := range logfileConfig {
-					switch k2.(string) {
-					case "reportInterval":
-						interval = v2.(string)
-					case "level":
-						level = v2.(string)
-					}
-				}
-				logFileReporter := metrics.NewLogFileReporter(logger.Logger(), level, metricRegistry, interval)
-				metricRegistry.RegisterReporter(logFileReporter)
-				logFileReporter.Start()
-			}
+		if rc.Logfile != nil {
+			logFileReporter := metrics.NewLogFileReporter(logger.Logger(), rc.Logfile.Level, metricRegistry, rc.Logfile.ReportInterval)
+			metricRegistry.RegisterReporter(logFileReporter)
+			logFileReporter.Start()
 		}
 	}
 }
@@ -105,9 +153,7 @@ func setupMetricReport(metricRegistry *metrics.MetricRegistry) {
 func getStartupPath() (appPath, configPath, dataPath, logPath string) {
 	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
-		logger.Loggers().Error("get boot path error: %v", err)
-		panic("get boot path error")
-		return
+		panic(fmt.Sprintf("get boot path error: %v", err))
 	}
 	dir = filepath.Clean(dir)
 	dir = filepath.ToSlash(dir)
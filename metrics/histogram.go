@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultReservoirSize = 1028
+
+// Histogram keeps the count and sum of every value and the last
+// defaultReservoirSize values, the percentiles are computed over these.
+type Histogram struct {
+	name    string
+	mutex   sync.Mutex
+	samples []int64
+	next    int
+	count   int64
+	sum     int64
+}
+
+type HistogramSnapshot struct {
+	Count int64
+	Sum   int64
+	Min   int64
+	Max   int64
+	Mean  float64
+	P50   float64
+	P95   float64
+	P99   float64
+}
+
+func NewHistogram(name string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		samples: make([]int64, 0, defaultReservoirSize),
+	}
+	return h
+}
+
+func (histogram *Histogram) Name() string {
+	return histogram.name
+}
+
+func (histogram *Histogram) Update(v int64) {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	histogram.count++
+	histogram.sum += v
+	if len(histogram.samples) < defaultReservoirSize {
+		histogram.samples = append(histogram.samples, v)
+		return
+	}
+	histogram.samples[histogram.next] = v
+	histogram.next = (histogram.next + 1) % defaultReservoirSize
+}
+
+func (histogram *Histogram) Snapshot() HistogramSnapshot {
+	histogram.mutex.Lock()
+	values := append([]int64{}, histogram.samples...)
+	s := HistogramSnapshot{
+		Count: histogram.count,
+		Sum:   histogram.sum,
+	}
+	histogram.mutex.Unlock()
+	if len(values) == 0 {
+		return s
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	var total int64
+	for _, v := range values {
+		total += v
+	}
+	s.Min = values[0]
+	s.Max = values[len(values)-1]
+	s.Mean = float64(total) / float64(len(values))
+	s.P50 = percentile(values, 0.5)
+	s.P95 = percentile(values, 0.95)
+	s.P99 = percentile(values, 0.99)
+	return s
+}
+
+// percentile interpolates between the closest ranks of the sorted values.
+func percentile(sorted []int64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return float64(sorted[lower])
+	}
+	return float64(sorted[lower]) + (pos-float64(lower))*float64(sorted[upper]-sorted[lower])
+}
+
+// Timer is a histogram of durations, kept in nanoseconds.
+type Timer struct {
+	*Histogram
+}
+
+func NewTimer(name string) *Timer {
+	return &Timer{Histogram: NewHistogram(name)}
+}
+
+func (timer *Timer) UpdateDuration(d time.Duration) {
+	timer.Update(int64(d))
+}
+
+func (timer *Timer) UpdateSince(start time.Time) {
+	timer.Update(int64(time.Since(start)))
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,15 +37,32 @@ func (gauge *Gauge) Value() int64 {
 	return gauge.getValue()
 }
 
+// Meter counts events and keeps the mean rate and the exponentially weighted
+// 1, 5 and 15 minute rates like the unix load average. Reading it has no side
+// effect, the rates are brought up to date lazily every tickInterval.
 type Meter struct {
-	name       string
-	value      int64
-	recordTime int64
+	name      string
+	count     int64
+	uncounted int64
+	startTime time.Time
+	lastTick  int64
+	mutex     sync.Mutex
+	m1        *ewma
+	m5        *ewma
+	m15       *ewma
 }
 
+const tickInterval = 5 * time.Second
+
 func NewMeter(name string) *Meter {
+	now := time.Now()
 	m := &Meter{
-		name: name,
+		name:      name,
+		startTime: now,
+		lastTick:  now.UnixNano(),
+		m1:        newEWMA(time.Minute),
+		m5:        newEWMA(5 * time.Minute),
+		m15:       newEWMA(15 * time.Minute),
 	}
 	return m
 }
@@ -54,18 +72,90 @@ func (meter *Meter) Name() string {
 }
 
 func (meter *Meter) Update(v int64) {
-	atomic.AddInt64(&meter.value, v)
-	atomic.CompareAndSwapInt64(&meter.recordTime, 0, time.Now().Unix())
+	atomic.AddInt64(&meter.count, v)
+	atomic.AddInt64(&meter.uncounted, v)
+	if time.Now().UnixNano()-atomic.LoadInt64(&meter.lastTick) >= int64(tickInterval) {
+		meter.tick()
+	}
+}
+
+func (meter *Meter) tick() {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	now := time.Now().UnixNano()
+	lastTick := atomic.LoadInt64(&meter.lastTick)
+	ticks := (now - lastTick) / int64(tickInterval)
+	if ticks <= 0 {
+		return
+	}
+	atomic.StoreInt64(&meter.lastTick, lastTick+ticks*int64(tickInterval))
+	uncounted := atomic.SwapInt64(&meter.uncounted, 0)
+	for _, e := range []*ewma{meter.m1, meter.m5, meter.m15} {
+		e.tick(uncounted, ticks)
+	}
 }
 
-func (meter *Meter) Value() int64 {
-	value := atomic.SwapInt64(&meter.value, 0)
-	currentTime := time.Now().Unix()
-	rTime := atomic.SwapInt64(&meter.recordTime, currentTime)
-	if currentTime - rTime == 0{
+func (meter *Meter) Count() int64 {
+	return atomic.LoadInt64(&meter.count)
+}
+
+// Rate1 is the events per second averaged over the last minute.
+func (meter *Meter) Rate1() float64 {
+	meter.tick()
+	return meter.m1.value()
+}
+
+func (meter *Meter) Rate5() float64 {
+	meter.tick()
+	return meter.m5.value()
+}
+
+func (meter *Meter) Rate15() float64 {
+	meter.tick()
+	return meter.m15.value()
+}
+
+// RateMean is the events per second since the meter was created.
+func (meter *Meter) RateMean() float64 {
+	elapsed := time.Since(meter.startTime).Seconds()
+	if elapsed <= 0 {
 		return 0
 	}
-	return value / (currentTime - rTime)
+	return float64(meter.Count()) / elapsed
+}
+
+type ewma struct {
+	mutex       sync.Mutex
+	alpha       float64
+	rate        float64
+	initialized bool
+}
+
+func newEWMA(window time.Duration) *ewma {
+	return &ewma{alpha: 1 - math.Exp(-tickInterval.Seconds()/window.Seconds())}
+}
+
+// tick adds count events seen over the last ticks intervals, all but the
+// first of which were empty.
+func (e *ewma) tick(count int64, ticks int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	instantRate := float64(count) / tickInterval.Seconds()
+	if e.initialized {
+		e.rate += e.alpha * (instantRate - e.rate)
+	} else {
+		e.rate = instantRate
+		e.initialized = true
+	}
+	if ticks > 1 {
+		e.rate *= math.Pow(1-e.alpha, float64(ticks-1))
+	}
+}
+
+func (e *ewma) value() float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.rate
 }
 
 type Counter struct {
@@ -96,6 +186,8 @@ func (counter *Counter) Value() int64 {
 	return counter.value
 }
 
+// InfoSheet describes the process, the values added by AddInfo do not change
+// while getInfo provides the current ones like the running time.
 type InfoSheet struct {
 	name    string
 	info    *sync.Map
@@ -120,6 +212,16 @@ func (info *InfoSheet) AddInfo(key string, value interface{}) {
 }
 
 func (info *InfoSheet) Info() *sync.Map {
-	info.getInfo(info.info)
+	values := &sync.Map{}
+	info.info.Range(func(key, value interface{}) bool {
+		values.Store(key, value)
+		return true
+	})
+	info.getInfo(values)
+	return values
+}
+
+// StaticInfo holds only the values added by AddInfo.
+func (info *InfoSheet) StaticInfo() *sync.Map {
 	return info.info
 }
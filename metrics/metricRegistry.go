@@ -1,16 +1,36 @@
 package metrics
 
-import "sync"
+import (
+	"sort"
+	"strings"
+	"sync"
+)
 
 type MetricRegistry struct {
-	metrics   sync.Map
-	reporters []Reporter
+	metrics     sync.Map
+	reporters   []Reporter
+	scopesMutex sync.RWMutex
+	scopes      map[string]*labelScope
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// labelScope describes the metrics named prefix-*, e.g. the metrics of a
+// tunnel, for the reporters that support labels.
+type labelScope struct {
+	prefix string
+	family string
+	labels []Label
 }
 
 func NewMetricRegstry() *MetricRegistry {
 	mr := &MetricRegistry{
 		reporters: make([]Reporter, 0),
 		metrics:   sync.Map{},
+		scopes:    make(map[string]*labelScope),
 	}
 	return mr
 }
@@ -18,6 +38,92 @@ func (mr *MetricRegistry) RegisterMetric(metric Metric) {
 	mr.metrics.Store(metric.Name(), metric)
 }
 
+// RegisterLabels attaches labels (name, value pairs) to the metrics whose
+// name is prefix or starts with prefix-, family is put in front of the rest
+// of their name. Nested prefixes inherit the labels of the outer ones.
+func (mr *MetricRegistry) RegisterLabels(prefix string, family string, labels ...string) {
+	scope := &labelScope{
+		prefix: prefix,
+		family: family,
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		scope.labels = append(scope.labels, Label{Name: labels[i], Value: labels[i+1]})
+	}
+	mr.scopesMutex.Lock()
+	defer mr.scopesMutex.Unlock()
+	mr.scopes[prefix] = scope
+}
+
+// UnregisterMetrics removes the metrics named prefix or prefix-* and their
+// labels, e.g. those of a stopped tunnel. The metrics under one of the keep
+// prefixes stay, they belong to another tunnel whose name starts the same way.
+func (mr *MetricRegistry) UnregisterMetrics(prefix string, keep ...string) {
+	under := func(name string, prefix string) bool {
+		return name == prefix || strings.HasPrefix(name, prefix+"-")
+	}
+	kept := func(name string) bool {
+		for _, k := range keep {
+			if under(name, k) {
+				return true
+			}
+		}
+		return false
+	}
+	mr.metrics.Range(func(key, value interface{}) bool {
+		name := key.(string)
+		if under(name, prefix) && !kept(name) {
+			mr.metrics.Delete(key)
+		}
+		return true
+	})
+	mr.scopesMutex.Lock()
+	defer mr.scopesMutex.Unlock()
+	for p := range mr.scopes {
+		if under(p, prefix) && !kept(p) {
+			delete(mr.scopes, p)
+		}
+	}
+}
+
+// Describe splits a metric name into its family name and labels.
+func (mr *MetricRegistry) Describe(name string) (string, []Label) {
+	mr.scopesMutex.RLock()
+	matched := make([]*labelScope, 0)
+	for prefix, scope := range mr.scopes {
+		if name == prefix || strings.HasPrefix(name, prefix+"-") {
+			matched = append(matched, scope)
+		}
+	}
+	mr.scopesMutex.RUnlock()
+	if len(matched) == 0 {
+		return name, nil
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return len(matched[i].prefix) < len(matched[j].prefix)
+	})
+	labels := make([]Label, 0)
+	for _, scope := range matched {
+	Next:
+		for _, l := range scope.labels {
+			for i := range labels {
+				if labels[i].Name == l.Name {
+					labels[i].Value = l.Value
+					continue Next
+				}
+			}
+			labels = append(labels, l)
+		}
+	}
+	scope := matched[len(matched)-1]
+	family := strings.TrimPrefix(strings.TrimPrefix(name, scope.prefix), "-")
+	if scope.family != "" && family != "" {
+		family = scope.family + "-" + family
+	} else if scope.family != "" {
+		family = scope.family
+	}
+	return family, labels
+}
+
 func (mr *MetricRegistry) RegisterReporter(reporter Reporter) {
 	mr.reporters = append(mr.reporters, reporter)
 }
@@ -33,6 +139,14 @@ func (mr *MetricRegistry) GetGauge(name string) *Gauge {
 	metric, _ := mr.metrics.Load(name)
 	return metric.(*Gauge)
 }
+func (mr *MetricRegistry) GetHistogram(name string) *Histogram {
+	metric, _ := mr.metrics.Load(name)
+	return metric.(*Histogram)
+}
+func (mr *MetricRegistry) GetTimer(name string) *Timer {
+	metric, _ := mr.metrics.Load(name)
+	return metric.(*Timer)
+}
 func (mr *MetricRegistry) GetInfoSheet(name string) *InfoSheet {
 	metric, _ := mr.metrics.Load(name)
 	return metric.(*InfoSheet)
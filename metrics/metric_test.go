@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestMeterRateDecay(t *testing.T) {
+	m := NewMeter("test")
+	m.Update(300)
+	m.lastTick -= int64(tickInterval)
+	if r := m.Rate1(); !almostEqual(r, 60) {
+		t.Fatalf("rate1 after one tick = %v, want 60", r)
+	}
+	if r := m.Rate15(); !almostEqual(r, 60) {
+		t.Fatalf("rate15 after one tick = %v, want 60", r)
+	}
+	//空闲一个窗口后速率衰减到1/e
+	m.lastTick -= int64(12 * tickInterval)
+	if r, want := m.Rate1(), 60*math.Exp(-1); !almostEqual(r, want) {
+		t.Errorf("rate1 after an idle minute = %v, want %v", r, want)
+	}
+	if r, want := m.Rate5(), 60*math.Exp(-0.2); !almostEqual(r, want) {
+		t.Errorf("rate5 after an idle minute = %v, want %v", r, want)
+	}
+	if m.Count() != 300 {
+		t.Errorf("count = %d, want 300", m.Count())
+	}
+}
+
+func TestEWMAMovesTowardsTheRate(t *testing.T) {
+	e := newEWMA(tickInterval)
+	e.tick(0, 1)
+	e.tick(50, 1)
+	if want := 10 * (1 - math.Exp(-1)); !almostEqual(e.value(), want) {
+		t.Errorf("rate = %v, want %v", e.value(), want)
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := NewHistogram("test")
+	if s := h.Snapshot(); s != (HistogramSnapshot{}) {
+		t.Fatalf("empty snapshot %+v", s)
+	}
+	for v := int64(100); v >= 1; v-- {
+		h.Update(v)
+	}
+	s := h.Snapshot()
+	want := HistogramSnapshot{Count: 100, Sum: 5050, Min: 1, Max: 100, Mean: 50.5, P50: 50.5, P95: 95.05, P99: 99.01}
+	if s.Count != want.Count || s.Sum != want.Sum || s.Min != want.Min || s.Max != want.Max ||
+		!almostEqual(s.Mean, want.Mean) || !almostEqual(s.P50, want.P50) ||
+		!almostEqual(s.P95, want.P95) || !almostEqual(s.P99, want.P99) {
+		t.Errorf("snapshot %+v, want %+v", s, want)
+	}
+}
+
+func TestHistogramKeepsRecentSamples(t *testing.T) {
+	h := NewHistogram("test")
+	n := int64(2 * defaultReservoirSize)
+	for v := int64(1); v <= n; v++ {
+		h.Update(v)
+	}
+	s := h.Snapshot()
+	if s.Count != n || s.Sum != n*(n+1)/2 {
+		t.Errorf("count %d sum %d", s.Count, s.Sum)
+	}
+	if s.Min != n-defaultReservoirSize+1 || s.Max != n {
+		t.Errorf("samples %d-%d, want the last %d values", s.Min, s.Max, defaultReservoirSize)
+	}
+}
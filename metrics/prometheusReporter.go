@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	prometheusNamespace   = "cleat"
+	defaultPrometheusPath = "/metrics"
+)
+
+// PrometheusReporter serves the metrics in the prometheus text exposition
+// format, the tunnel, output and similar parts of the metric names become labels.
+type PrometheusReporter struct {
+	mr     *MetricRegistry
+	logger *zap.Logger
+	listen string
+	path   string
+	server *http.Server
+}
+
+type prometheusSample struct {
+	suffix string
+	labels []Label
+	value  string
+}
+
+type prometheusFamily struct {
+	metricType string
+	samples    []prometheusSample
+}
+
+func NewPrometheusReporter(logger *zap.Logger, mr *MetricRegistry, listen string, path string) *PrometheusReporter {
+	if path == "" {
+		path = defaultPrometheusPath
+	}
+	return &PrometheusReporter{
+		mr:     mr,
+		logger: logger,
+		listen: listen,
+		path:   path,
+	}
+}
+
+func (pr *PrometheusReporter) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pr.path, pr.handle)
+	pr.server = &http.Server{Addr: pr.listen, Handler: mux}
+	listener, err := net.Listen("tcp", pr.listen)
+	if err != nil {
+		pr.logger.Error(fmt.Sprintf("prometheus reporter listen error: %s,%v", pr.listen, err))
+		return
+	}
+	pr.logger.Info(fmt.Sprintf("prometheus reporter listen on %s%s", listener.Addr(), pr.path))
+	go func() {
+		if err := pr.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			pr.logger.Error(fmt.Sprintf("prometheus reporter serve error: %v", err))
+		}
+	}()
+}
+
+func (pr *PrometheusReporter) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(pr.render())
+}
+
+func (pr *PrometheusReporter) render() []byte {
+	families := make(map[string]*prometheusFamily)
+	add := func(name string, metricType string, suffix string, labels []Label, value string) {
+		f, ok := families[name]
+		if !ok {
+			f = &prometheusFamily{metricType: metricType}
+			families[name] = f
+		}
+		f.samples = append(f.samples, prometheusSample{suffix: suffix, labels: labels, value: value})
+	}
+	for name, metric := range pr.mr.Metrics() {
+		family, labels := pr.mr.Describe(name)
+		family = prometheusNamespace + "_" + prometheusName(family)
+		switch m := metric.(type) {
+		case *Gauge:
+			add(family, "gauge", "", labels, fmt.Sprint(m.Value()))
+		case *Meter:
+			add(family+"_total", "counter", "", labels, fmt.Sprint(m.Count()))
+			for _, r := range []struct {
+				window string
+				rate   float64
+			}{{"1m", m.Rate1()}, {"5m", m.Rate5()}, {"15m", m.Rate15()}, {"mean", m.RateMean()}} {
+				add(family, "gauge", "", append(append([]Label{}, labels...), Label{Name: "window", Value: r.window}), fmt.Sprint(r.rate))
+			}
+		case *Counter:
+			//Counter也被当作可增减的计数使用，只有*_total按counter类型输出
+			metricType := "gauge"
+			if strings.HasSuffix(family, "_total") {
+				metricType = "counter"
+			}
+			add(family, metricType, "", labels, fmt.Sprint(m.Value()))
+		case *Histogram:
+			addSummary(add, family, labels, m.Snapshot(), 1)
+		case *Timer:
+			addSummary(add, family+"_seconds", labels, m.Snapshot(), float64(time.Second))
+		case *InfoSheet:
+			//变化的值作为标签时每次都会产生新的时间序列，只输出固定的值
+			infoLabels := append([]Label{}, labels...)
+			m.StaticInfo().Range(func(key interface{}, value interface{}) bool {
+				infoLabels = append(infoLabels, Label{Name: fmt.Sprint(key), Value: fmt.Sprint(value)})
+				return true
+			})
+			add(family+"_info", "gauge", "", infoLabels, "1")
+		}
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		f := families[name]
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, f.metricType)
+		lines := make([]string, 0, len(f.samples))
+		for _, s := range f.samples {
+			lines = append(lines, name+s.suffix+prometheusLabels(s.labels)+" "+s.value+"\n")
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			buf.WriteString(line)
+		}
+	}
+	return buf.Bytes()
+}
+
+// addSummary reports a histogram as a summary, values are divided by unit.
+func addSummary(add func(string, string, string, []Label, string), family string, labels []Label, s HistogramSnapshot, unit float64) {
+	for _, q := range []struct {
+		quantile string
+		value    float64
+	}{{"0.5", s.P50}, {"0.95", s.P95}, {"0.99", s.P99}} {
+		add(family, "summary", "", append(append([]Label{}, labels...), Label{Name: "quantile", Value: q.quantile}), fmt.Sprint(q.value/unit))
+	}
+	add(family, "summary", "_sum", labels, fmt.Sprint(float64(s.Sum)/unit))
+	add(family, "summary", "_count", labels, fmt.Sprint(s.Count))
+	add(family+"_max", "gauge", "", labels, fmt.Sprint(float64(s.Max)/unit))
+}
+
+func prometheusLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sorted := append([]Label{}, labels...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	parts := make([]string, 0, len(sorted))
+	for _, l := range sorted {
+		parts = append(parts, prometheusName(l.Name)+`="`+labelValueReplacer.Replace(l.Value)+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusName turns a metric or label name into [a-zA-Z_][a-zA-Z0-9_]*.
+func prometheusName(name string) string {
+	var sb strings.Builder
+	underscore := false
+	for _, r := range name {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			sb.WriteRune(r)
+			underscore = r == '_'
+			continue
+		}
+		if !underscore {
+			sb.WriteByte('_')
+			underscore = true
+		}
+	}
+	sanitized := strings.Trim(sb.String(), "_")
+	if sanitized == "" || sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+func (pr *PrometheusReporter) Stop() {
+	if pr.server != nil {
+		pr.server.Close()
+	}
+}
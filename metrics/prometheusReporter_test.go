@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrometheusInfoSheetIsStable(t *testing.T) {
+	mr := NewMetricRegstry()
+	calls := 0
+	info := NewInfoSheet("system_overview", func(infoValues *sync.Map) {
+		calls++
+		infoValues.Store("runningTime", fmt.Sprintf("%ds", calls))
+	})
+	info.AddInfo("OS", "linux")
+	mr.RegisterMetric(info)
+	pr := NewPrometheusReporter(zap.NewNop(), mr, "127.0.0.1:0", "")
+	first := string(pr.render())
+	if second := string(pr.render()); first != second {
+		t.Fatalf("info changed between scrapes:\n%s\n%s", first, second)
+	}
+	if want := `cleat_system_overview_info{OS="linux"} 1`; !strings.Contains(first, want) {
+		t.Errorf("exposition %q does not contain %s", first, want)
+	}
+	if v, _ := info.Info().Load("runningTime"); v == nil {
+		t.Error("running time missing from the info of the log reporter")
+	}
+	if _, ok := info.StaticInfo().Load("runningTime"); ok {
+		t.Error("running time stored with the static values")
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	tests := map[string]string{
+		"channal-size":             "channal_size",
+		"windowevent[Application]": "windowevent_Application",
+		"a--b..c":                  "a_b_c",
+		"9lives":                   "_9lives",
+		"日志":                       "_",
+		"-output-":                 "output",
+		"ok_name":                  "ok_name",
+	}
+	for name, want := range tests {
+		if got := prometheusName(name); got != want {
+			t.Errorf("prometheusName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPrometheusExposition(t *testing.T) {
+	mr := NewMetricRegstry()
+	mr.RegisterLabels("filelog", "", "tunnel", "filelog")
+	mr.RegisterLabels("filelog-siem", "", "output", "siem")
+	mr.RegisterLabels("filelog-processor[grok]", "processor", "processor", "grok")
+	mr.RegisterMetric(NewGauge("filelog-channal-size", func() int64 { return 3 }))
+	sent := NewCounter("filelog-siem-send-error-total")
+	sent.Incr(2)
+	mr.RegisterMetric(sent)
+	mr.RegisterMetric(NewCounter("filelog-processor[grok]-in"))
+	meter := NewMeter("filelog-siem-send-rate")
+	meter.Update(5)
+	mr.RegisterMetric(meter)
+	timer := NewTimer("filelog-siem-write-time")
+	timer.UpdateDuration(2 * time.Second)
+	mr.RegisterMetric(timer)
+	mr.RegisterLabels("quote", "", "path", "C:\\logs\\\"a\"\n")
+	mr.RegisterMetric(NewGauge("quote-size", func() int64 { return 1 }))
+
+	got := string(NewPrometheusReporter(zap.NewNop(), mr, "127.0.0.1:0", "").render())
+	for _, want := range []string{
+		"# TYPE cleat_channal_size gauge\ncleat_channal_size{tunnel=\"filelog\"} 3\n",
+		"# TYPE cleat_send_error_total counter\ncleat_send_error_total{output=\"siem\",tunnel=\"filelog\"} 2\n",
+		"# TYPE cleat_processor_in gauge\ncleat_processor_in{processor=\"grok\",tunnel=\"filelog\"} 0\n",
+		"# TYPE cleat_send_rate_total counter\ncleat_send_rate_total{output=\"siem\",tunnel=\"filelog\"} 5\n",
+		"cleat_send_rate{output=\"siem\",tunnel=\"filelog\",window=\"1m\"} 0\n",
+		"# TYPE cleat_write_time_seconds summary\n",
+		"cleat_write_time_seconds{output=\"siem\",quantile=\"0.99\",tunnel=\"filelog\"} 2\n",
+		"cleat_write_time_seconds_count{output=\"siem\",tunnel=\"filelog\"} 1\n",
+		"cleat_write_time_seconds_sum{output=\"siem\",tunnel=\"filelog\"} 2\n",
+		"cleat_write_time_seconds_max{output=\"siem\",tunnel=\"filelog\"} 2\n",
+		"cleat_size{path=\"C:\\\\logs\\\\\\\"a\\\"\\n\"} 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("exposition does not contain %q:\n%s", want, got)
+		}
+	}
+	families := make([]string, 0)
+	for _, line := range strings.Split(got, "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			families = append(families, strings.Fields(line)[2])
+		}
+	}
+	if !sort.StringsAreSorted(families) {
+		t.Errorf("families not sorted: %v", families)
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	mr := NewMetricRegstry()
+	mr.RegisterMetric(NewGauge("start_time_seconds", func() int64 { return 42 }))
+	pr := NewPrometheusReporter(zap.NewNop(), mr, "127.0.0.1:0", "")
+	w := httptest.NewRecorder()
+	pr.handle(w, httptest.NewRequest("GET", defaultPrometheusPath, nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	if body := w.Body.String(); body != "# TYPE cleat_start_time_seconds gauge\ncleat_start_time_seconds 42\n" {
+		t.Errorf("body %q", body)
+	}
+}
@@ -23,11 +23,7 @@ type LogFileReporter struct {
 	logLevel       string
 }
 
-func NewLogFileReporter(logger *zap.Logger, logLevel string, mr *MetricRegistry, duration string) *LogFileReporter {
-	d, err := time.ParseDuration(duration)
-	if err != nil {
-		return nil
-	}
+func NewLogFileReporter(logger *zap.Logger, logLevel string, mr *MetricRegistry, d time.Duration) *LogFileReporter {
 	reporter := &LogFileReporter{
 		mr:             mr,
 		logger:         logger,
@@ -59,6 +55,10 @@ func (lfr *LogFileReporter) report() {
 			lfr.reportMeter(name, metric.(*Meter))
 		case "Counter":
 			lfr.reportCounter(name, metric.(*Counter))
+		case "Histogram":
+			lfr.reportHistogram(name, metric.(*Histogram))
+		case "Timer":
+			lfr.reportTimer(name, metric.(*Timer))
 		case "InfoSheet":
 			lfr.reportInfoSheet(name, metric.(*InfoSheet))
 		}
@@ -85,11 +85,22 @@ func (lfr *LogFileReporter) reportGauge(name string, metric *Gauge) {
 	lfr.printBuffer.WriteString(fmt.Sprintf("\nGauge-(%s): %d", name, metric.Value()))
 }
 func (lfr *LogFileReporter) reportMeter(name string, metric *Meter) {
-	lfr.printBuffer.WriteString(fmt.Sprintf("\nMeter-(%s): %d /sec", name, metric.Value()))
+	lfr.printBuffer.WriteString(fmt.Sprintf("\nMeter-(%s): count=%d mean=%.2f 1m=%.2f 5m=%.2f 15m=%.2f /sec",
+		name, metric.Count(), metric.RateMean(), metric.Rate1(), metric.Rate5(), metric.Rate15()))
 }
 func (lfr *LogFileReporter) reportCounter(name string, metric *Counter) {
 	lfr.printBuffer.WriteString(fmt.Sprintf("\nCounter-(%s): %d", name, metric.Value()))
 }
+func (lfr *LogFileReporter) reportHistogram(name string, metric *Histogram) {
+	s := metric.Snapshot()
+	lfr.printBuffer.WriteString(fmt.Sprintf("\nHistogram-(%s): count=%d min=%d mean=%.2f p50=%.2f p95=%.2f p99=%.2f max=%d",
+		name, s.Count, s.Min, s.Mean, s.P50, s.P95, s.P99, s.Max))
+}
+func (lfr *LogFileReporter) reportTimer(name string, metric *Timer) {
+	s := metric.Snapshot()
+	lfr.printBuffer.WriteString(fmt.Sprintf("\nTimer-(%s): count=%d min=%v mean=%v p50=%v p95=%v p99=%v max=%v",
+		name, s.Count, time.Duration(s.Min), time.Duration(s.Mean), time.Duration(s.P50), time.Duration(s.P95), time.Duration(s.P99), time.Duration(s.Max)))
+}
 func (lfr *LogFileReporter) reportInfoSheet(name string, metric *InfoSheet) {
 	lfr.printBuffer.WriteString(fmt.Sprintf("\nInfoSheet-(%s): ", name))
 	metric.Info().Range(func(key interface{}, value interface{}) bool {
@@ -1,10 +1,13 @@
 package output
 
 import (
+	"fmt"
 	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/metrics"
-	"github.com/pkg/errors"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Output interface {
@@ -14,64 +17,135 @@ type Output interface {
 }
 
 type TCPOutputConfig struct {
-	Server     string
-	ServerPort int
+	Server       string        `mapstructure:"serverIP"`
+	ServerPort   int           `mapstructure:"serverPort"`
+	Framing      string        `mapstructure:"framing"`
+	DialTimeout  time.Duration `mapstructure:"dialTimeout"`
+	WriteTimeout time.Duration `mapstructure:"writeTimeout"`
+	BackoffMin   time.Duration `mapstructure:"backoffMin"`
+	BackoffMax   time.Duration `mapstructure:"backoffMax"`
+	TLS          *TLSConfig    `mapstructure:"tls"`
+	Syslog       SyslogConfig  `mapstructure:",squash"`
 }
 
 type UDPOutputConfig struct {
-	Server     string
-	ServerPort int
+	Server     string       `mapstructure:"serverIP"`
+	ServerPort int          `mapstructure:"serverPort"`
+	Syslog     SyslogConfig `mapstructure:",squash"`
 }
 
-func BuildOutput(queue chan string, metricRegistry *metrics.MetricRegistry, tunnelName string) (Output, error) {
-	outputType, udpConig, tcpConfig, err := parseConfig()
+// Parser decodes and checks the raw config section of an output type, the
+// errors are reported as a *config.Errors with key paths relative to the section.
+type Parser func(conf interface{}) (interface{}, error)
+
+// Builder creates an output of one type from the config returned by its
+// Parser, name is unique per output and prefixes its metrics.
+type Builder func(conf interface{}, queue chan *event.Event, metricRegistry *metrics.MetricRegistry, name string) (Output, error)
+
+type registration struct {
+	parser  Parser
+	builder Builder
+}
+
+var (
+	buildersMutex sync.RWMutex
+	builders      = make(map[string]registration)
+)
+
+func init() {
+	RegisterOutput("udp", parseUDPConfig, func(conf interface{}, queue chan *event.Event, metricRegistry *metrics.MetricRegistry, name string) (Output, error) {
+		return NewUDPOutput(conf.(*UDPOutputConfig), queue, metricRegistry, name)
+	})
+	RegisterOutput("tcp", parseTCPConfig, func(conf interface{}, queue chan *event.Event, metricRegistry *metrics.MetricRegistry, name string) (Output, error) {
+		return NewTCPOutput(conf.(*TCPOutputConfig), queue, metricRegistry, name)
+	})
+}
+
+func RegisterOutput(outputType string, parser Parser, builder Builder) {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+	builders[outputType] = registration{parser: parser, builder: builder}
+}
+
+func lookup(outputType string) (registration, error) {
+	buildersMutex.RLock()
+	r, ok := builders[strings.ToLower(outputType)]
+	buildersMutex.RUnlock()
+	if !ok {
+		return r, fmt.Errorf("unknown output type: %s", outputType)
+	}
+	return r, nil
+}
+
+func ParseOutput(outputType string, conf interface{}) (interface{}, error) {
+	r, err := lookup(outputType)
 	if err != nil {
 		return nil, err
 	}
-	var output Output
-	if outputType == "udp" {
-		output = NewUDPOutput(udpConig, queue, metricRegistry, tunnelName)
-	} else if outputType == "tcp" {
-		output = NewTCPOutput(tcpConfig, queue, metricRegistry, tunnelName)
+	return r.parser(conf)
+}
+
+func BuildOutput(outputType string, conf interface{}, queue chan *event.Event, metricRegistry *metrics.MetricRegistry, name string) (Output, error) {
+	r, err := lookup(outputType)
+	if err != nil {
+		return nil, err
 	}
-	return output, nil
+	return r.builder(conf, queue, metricRegistry, name)
 }
 
-func parseConfig() (string, *UDPOutputConfig, *TCPOutputConfig, error) {
-	stringMap := config.Config().GetStringMap("output")
-	for key, value := range stringMap {
-		if key == "udp" {
-			udpConfig := &UDPOutputConfig{}
-			valueMap, ok := value.(map[string]interface{})
-			if !ok {
-				return "", nil, nil, errors.New("parse output config error")
-			}
-			for k, v := range valueMap {
-				if strings.ToLower(k) == "serverip" {
-					udpConfig.Server = v.(string)
-				}
-				if strings.ToLower(k) == "serverport" {
-					udpConfig.ServerPort = v.(int)
-				}
-			}
-			return key, udpConfig, nil, nil
-		}
-		if key == "tcp" {
-			tcpConfig := &TCPOutputConfig{}
-			valueMap, ok := value.(map[string]interface{})
-			if !ok {
-				return "", nil, nil, errors.New("parse output config error")
-			}
-			for k, v := range valueMap {
-				if strings.ToLower(k) == "serverip" {
-					tcpConfig.Server = v.(string)
-				}
-				if strings.ToLower(k) == "serverport" {
-					tcpConfig.ServerPort = v.(int)
-				}
-			}
-			return key, nil, tcpConfig, nil
+func parseUDPConfig(conf interface{}) (interface{}, error) {
+	udpConfig := &UDPOutputConfig{}
+	if err := config.Decode(conf, udpConfig); err != nil {
+		return nil, err
+	}
+	errs := &config.Errors{}
+	checkServer(udpConfig.Server, udpConfig.ServerPort, errs)
+	_, err := newSyslogFormatter(udpConfig.Syslog)
+	errs.Merge("", err)
+	return udpConfig, errs.Err()
+}
+
+func parseTCPConfig(conf interface{}) (interface{}, error) {
+	tcpConfig := &TCPOutputConfig{}
+	if err := config.Decode(conf, tcpConfig); err != nil {
+		return nil, err
+	}
+	errs := &config.Errors{}
+	checkServer(tcpConfig.Server, tcpConfig.ServerPort, errs)
+	tcpConfig.Framing = strings.ToLower(tcpConfig.Framing)
+	switch tcpConfig.Framing {
+	case "":
+		tcpConfig.Framing = FramingNewline
+	case FramingNewline, FramingOctet:
+	default:
+		errs.Add("framing", "must be %s or %s", FramingNewline, FramingOctet)
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{{"dialTimeout", tcpConfig.DialTimeout}, {"writeTimeout", tcpConfig.WriteTimeout},
+		{"backoffMin", tcpConfig.BackoffMin}, {"backoffMax", tcpConfig.BackoffMax}} {
+		if d.value < 0 {
+			errs.Add(d.key, "must not be negative")
 		}
 	}
-	return "", nil, nil, errors.New("parse output config error")
+	if tcpConfig.TLS != nil && tcpConfig.TLS.Enabled != nil && !*tcpConfig.TLS.Enabled {
+		tcpConfig.TLS = nil
+	}
+	if tcpConfig.TLS != nil {
+		_, err := buildTLSConfig(tcpConfig.TLS, tcpConfig.Server)
+		errs.Merge("tls", err)
+	}
+	_, err := newSyslogFormatter(tcpConfig.Syslog)
+	errs.Merge("", err)
+	return tcpConfig, errs.Err()
+}
+
+func checkServer(server string, port int, errs *config.Errors) {
+	if strings.TrimSpace(server) == "" {
+		errs.Add("serverIP", "must not be empty")
+	}
+	if port <= 0 || port > 65535 {
+		errs.Add("serverPort", "must be between 1 and 65535")
+	}
 }
@@ -0,0 +1,264 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatRaw     = "raw"
+	FormatRFC3164 = "rfc3164"
+	FormatRFC5424 = "rfc5424"
+
+	FramingNewline = "newline"
+	FramingOctet   = "octet"
+
+	defaultSDID = "cleat@32473"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var severities = map[string]int{
+	"emerg":         0,
+	"emergency":     0,
+	"panic":         0,
+	"alert":         1,
+	"crit":          2,
+	"critical":      2,
+	"fatal":         2,
+	"err":           3,
+	"error":         3,
+	"warning":       4,
+	"warn":          4,
+	"notice":        5,
+	"info":          6,
+	"information":   6,
+	"informational": 6,
+	"debug":         7,
+	"trace":         7,
+	"verbose":       7,
+}
+
+// windows event levels, 0 is LogAlways which the security channel uses for audits
+var windowsLevelSeverities = map[string]int{
+	"0": 6, "1": 2, "2": 3, "3": 4, "4": 6, "5": 7,
+}
+
+// SyslogConfig selects how events are written. The severity of an event is
+// looked up by the value of SeverityField in SeverityMap and the built-in level
+// names, Severity is used when nothing matches.
+type SyslogConfig struct {
+	Format        string            `mapstructure:"format"`
+	Facility      string            `mapstructure:"facility"`
+	Severity      string            `mapstructure:"severity"`
+	SeverityField string            `mapstructure:"severityField"`
+	SeverityMap   map[string]string `mapstructure:"severityMap"`
+	AppName       string            `mapstructure:"appName"`
+	SDID          string            `mapstructure:"sdID"`
+}
+
+type syslogFormatter struct {
+	format        string
+	facility      int
+	severity      int
+	severityField string
+	severityMap   map[string]int
+	appName       string
+	sdID          string
+}
+
+func newSyslogFormatter(conf SyslogConfig) (*syslogFormatter, error) {
+	f := &syslogFormatter{
+		format:        strings.ToLower(conf.Format),
+		facility:      facilities["user"],
+		severity:      severities["info"],
+		severityField: conf.SeverityField,
+		severityMap:   make(map[string]int),
+		appName:       conf.AppName,
+		sdID:          conf.SDID,
+	}
+	errs := &config.Errors{}
+	switch f.format {
+	case "":
+		f.format = FormatRaw
+	case FormatRaw, FormatRFC3164, FormatRFC5424:
+	default:
+		errs.Add("format", "unknown syslog format: %s", conf.Format)
+	}
+	if conf.Facility != "" {
+		facility, err := lookupCode(facilities, conf.Facility, 23)
+		if err != nil {
+			errs.Add("facility", "%v", err)
+		}
+		f.facility = facility
+	}
+	if conf.Severity != "" {
+		severity, err := lookupCode(severities, conf.Severity, 7)
+		if err != nil {
+			errs.Add("severity", "%v", err)
+		}
+		f.severity = severity
+	}
+	values := make([]string, 0, len(conf.SeverityMap))
+	for value := range conf.SeverityMap {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		severity, err := lookupCode(severities, conf.SeverityMap[value], 7)
+		if err != nil {
+			errs.Add("severityMap."+value, "%v", err)
+		}
+		f.severityMap[strings.ToLower(value)] = severity
+	}
+	if errs.Len() > 0 {
+		return nil, errs
+	}
+	if f.severityField == "" {
+		f.severityField = "level"
+	}
+	if f.appName == "" {
+		f.appName = "cleat"
+	}
+	if f.sdID == "" {
+		f.sdID = defaultSDID
+	}
+	return f, nil
+}
+
+func lookupCode(names map[string]int, value string, max int) (int, error) {
+	if code, ok := names[strings.ToLower(value)]; ok {
+		return code, nil
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil || code < 0 || code > max {
+		return 0, errors.Errorf("unknown value: %s", value)
+	}
+	return code, nil
+}
+
+func (f *syslogFormatter) eventSeverity(e *event.Event) int {
+	v, ok := e.GetField(f.severityField)
+	if !ok {
+		return f.severity
+	}
+	level := strings.ToLower(cast.ToString(v))
+	if severity, ok := f.severityMap[level]; ok {
+		return severity
+	}
+	if severity, ok := severities[level]; ok {
+		return severity
+	}
+	if _, ok := e.GetField("channel"); ok {
+		if severity, ok := windowsLevelSeverities[level]; ok {
+			return severity
+		}
+	}
+	return f.severity
+}
+
+// Format writes e into buf according to the configured syslog format.
+func (f *syslogFormatter) Format(e *event.Event, buf *bytes.Buffer) {
+	if f.format == FormatRaw {
+		buf.WriteString(e.Message)
+		return
+	}
+	pri := f.facility*8 + f.eventSeverity(e)
+	host := headerValue(e.Host, 255)
+	if f.format == FormatRFC3164 {
+		fmt.Fprintf(buf, "<%d>%s %s %s: %s", pri, e.Timestamp.Format(time.Stamp), host, headerValue(f.appName, 32), e.Message)
+		return
+	}
+	fmt.Fprintf(buf, "<%d>1 %s %s %s - - ", pri, e.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"), host, headerValue(f.appName, 48))
+	f.writeStructuredData(e, buf)
+	if e.Message != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(e.Message)
+	}
+}
+
+// writeStructuredData puts the event fields into one SD-ELEMENT.
+func (f *syslogFormatter) writeStructuredData(e *event.Event, buf *bytes.Buffer) {
+	if len(e.Fields) == 0 {
+		buf.WriteByte('-')
+		return
+	}
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf.WriteByte('[')
+	buf.WriteString(sdName(f.sdID))
+	for _, k := range keys {
+		buf.WriteByte(' ')
+		buf.WriteString(sdName(k))
+		buf.WriteString(`="`)
+		for _, r := range cast.ToString(e.Fields[k]) {
+			if r == '"' || r == '\\' || r == ']' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteRune(r)
+		}
+		buf.WriteByte('"')
+	}
+	buf.WriteByte(']')
+}
+
+// headerValue makes a syslog header field of printable ascii without spaces.
+func headerValue(v string, max int) string {
+	if v == "" {
+		return "-"
+	}
+	b := make([]byte, 0, len(v))
+	for i := 0; i < len(v) && len(b) < max; i++ {
+		if v[i] > 32 && v[i] < 127 {
+			b = append(b, v[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+func sdName(v string) string {
+	b := make([]byte, 0, len(v))
+	for i := 0; i < len(v) && len(b) < 32; i++ {
+		c := v[i]
+		if c > 32 && c < 127 && c != '=' && c != ']' && c != '"' {
+			b = append(b, c)
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// frame writes one formatted message into the stream according to framing,
+// octet counting follows RFC 6587.
+func frame(framing string, msg []byte, buf *bytes.Buffer) {
+	if framing == FramingOctet {
+		buf.WriteString(strconv.Itoa(len(msg)))
+		buf.WriteByte(' ')
+		buf.Write(msg)
+		return
+	}
+	buf.Write(msg)
+	if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+}
@@ -2,78 +2,258 @@ package output
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
+	"math/rand"
 	"net"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDialTimeout  = 5 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+	defaultBackoffMin   = time.Second
+	defaultBackoffMax   = time.Minute
+	idleCheckInterval   = time.Second
 )
 
 type TCPOutput struct {
 	server            string
 	serverPort        int
-	queue             chan string
-	tcpConn           *net.TCPConn
+	queue             chan *event.Event
+	tcpConn           net.Conn
 	waitGroup         sync.WaitGroup
+	stopChan          chan struct{}
+	stopOnce          sync.Once
 	sendMeter         *metrics.Meter
 	recordTotalMetric *metrics.Counter
+	queueWaitTimer    *metrics.Timer
+	writeTimer        *metrics.Timer
+	connectedGauge    *metrics.Gauge
+	reconnectCounter  *metrics.Counter
+	sendErrorCounter  *metrics.Counter
+	connected         int64
 	dataBuffer        bytes.Buffer
+	messageBuffer     bytes.Buffer
+	formatter         *syslogFormatter
+	framing           string
+	tlsConfig         *tls.Config
+	dialTimeout       time.Duration
+	writeTimeout      time.Duration
+	backoffMin        time.Duration
+	backoffMax        time.Duration
+	lastWrite         time.Time
 }
 
-func NewTCPOutput(tcpConfig *TCPOutputConfig, queue chan string, metricRegistry *metrics.MetricRegistry, tunnelName string) *TCPOutput {
+func NewTCPOutput(tcpConfig *TCPOutputConfig, queue chan *event.Event, metricRegistry *metrics.MetricRegistry, name string) (*TCPOutput, error) {
+	formatter, err := newSyslogFormatter(tcpConfig.Syslog)
+	if err != nil {
+		return nil, err
+	}
+	//不修改解析后的配置，重新加载时要与新配置比较
+	framing := tcpConfig.Framing
+	switch framing {
+	case "":
+		framing = FramingNewline
+	case FramingNewline, FramingOctet:
+	default:
+		return nil, fmt.Errorf("unknown tcp framing: %s", framing)
+	}
 	output := &TCPOutput{
-		server:     tcpConfig.Server,
-		serverPort: tcpConfig.ServerPort,
-		queue:      queue,
+		formatter:    formatter,
+		framing:      framing,
+		server:       tcpConfig.Server,
+		serverPort:   tcpConfig.ServerPort,
+		queue:        queue,
+		stopChan:     make(chan struct{}),
+		dialTimeout:  durationOrDefault(tcpConfig.DialTimeout, defaultDialTimeout),
+		writeTimeout: durationOrDefault(tcpConfig.WriteTimeout, defaultWriteTimeout),
+		backoffMin:   durationOrDefault(tcpConfig.BackoffMin, defaultBackoffMin),
+		backoffMax:   durationOrDefault(tcpConfig.BackoffMax, defaultBackoffMax),
 	}
-	sendMeter := metrics.NewMeter(tunnelName + "-tcpoutput-rate")
+	if tcpConfig.TLS != nil {
+		output.tlsConfig, err = buildTLSConfig(tcpConfig.TLS, tcpConfig.Server)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if output.backoffMax < output.backoffMin {
+		output.backoffMax = output.backoffMin
+	}
+	sendMeter := metrics.NewMeter(name + "-tcpoutput-rate")
 	metricRegistry.RegisterMetric(sendMeter)
 	output.sendMeter = sendMeter
-	output.recordTotalMetric = metricRegistry.GetCounter(tunnelName + "-output-record-total")
-	return output
+	recordTotalMetric := metrics.NewCounter(name + "-output-record-total")
+	metricRegistry.RegisterMetric(recordTotalMetric)
+	output.recordTotalMetric = recordTotalMetric
+	output.queueWaitTimer = metrics.NewTimer(name + "-output-queue-wait-time")
+	metricRegistry.RegisterMetric(output.queueWaitTimer)
+	output.writeTimer = metrics.NewTimer(name + "-output-write-time")
+	metricRegistry.RegisterMetric(output.writeTimer)
+	output.connectedGauge = metrics.NewGauge(name+"-tcpoutput-connected", func() int64 {
+		return atomic.LoadInt64(&output.connected)
+	})
+	metricRegistry.RegisterMetric(output.connectedGauge)
+	output.reconnectCounter = metrics.NewCounter(name + "-tcpoutput-reconnect-total")
+	metricRegistry.RegisterMetric(output.reconnectCounter)
+	output.sendErrorCounter = metrics.NewCounter(name + "-tcpoutput-send-error-total")
+	metricRegistry.RegisterMetric(output.sendErrorCounter)
+	return output, nil
+}
+
+func durationOrDefault(d time.Duration, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
 }
 
+func (output *TCPOutput) address() string {
+	return fmt.Sprintf("%s:%d", output.server, output.serverPort)
+}
+
+// Start is called before Process, which Stop waits for.
 func (output *TCPOutput) Start() {
-	var addr *net.TCPAddr
+	output.waitGroup.Add(1)
+	logger.Loggers().Infof("tcp server address：%s, tls: %v", output.address(), output.tlsConfig != nil)
+	if err := output.connect(); err != nil {
+		logger.Loggers().Error("connect tcp server error:", err)
+	}
+}
+
+func (output *TCPOutput) connect() error {
+	var conn net.Conn
 	var err error
-	addrs := fmt.Sprintf("%s:%d", output.server, output.serverPort)
-	logger.Loggers().Info("tcp server address：", addrs)
-	addr, err = net.ResolveTCPAddr("tcp", addrs)
-	if err != nil {
-		logger.Loggers().Error("tcp adress resolve error:", err)
-		return
+	if output.tlsConfig != nil {
+		dialer := &net.Dialer{Timeout: output.dialTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", output.address(), output.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", output.address(), output.dialTimeout)
 	}
-	output.tcpConn, err = net.DialTCP("tcp", nil, addr)
 	if err != nil {
-		logger.Loggers().Error("connect tcp server error:", err)
-		return
+		return err
+	}
+	output.tcpConn = conn
+	atomic.StoreInt64(&output.connected, 1)
+	return nil
+}
+
+func (output *TCPOutput) disconnect() {
+	if output.tcpConn != nil {
+		output.tcpConn.Close()
+		output.tcpConn = nil
+	}
+	atomic.StoreInt64(&output.connected, 0)
+}
+
+func (output *TCPOutput) reconnect() bool {
+	return redial(output.stopChan, output.backoffMin, output.backoffMax, output.reconnectCounter, output.connect, "tcp", output.address())
+}
+
+// redial calls connect until it succeeds, waiting an exponentially growing and
+// jittered delay between attempts. It gives up when stopChan is closed.
+func redial(stopChan chan struct{}, backoffMin, backoffMax time.Duration, counter *metrics.Counter, connect func() error, network, address string) bool {
+	backoff := backoffMin
+	for {
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-stopChan:
+			return false
+		case <-time.After(delay):
+		}
+		counter.Incr(1)
+		err := connect()
+		if err == nil {
+			logger.Loggers().Infof("%s server reconnected: %s", network, address)
+			return true
+		}
+		logger.Loggers().Warnf("reconnect %s server error, retry in %v: %v", network, backoff, err)
+		backoff *= 2
+		if backoff > backoffMax {
+			backoff = backoffMax
+		}
 	}
 }
 
 func (output *TCPOutput) Process() {
-	output.waitGroup.Add(1)
 	defer output.waitGroup.Done()
 	for data := range output.queue {
+		output.queueWaitTimer.UpdateSince(data.Created())
+		output.messageBuffer.Reset()
+		output.formatter.Format(data, &output.messageBuffer)
 		output.dataBuffer.Reset()
-		output.dataBuffer.WriteString(data)
-		if !strings.HasSuffix(data, "\n") {
-			output.dataBuffer.WriteString("\n")
-		}
-		_, err := output.tcpConn.Write(output.dataBuffer.Bytes())
-		if err != nil {
-			logger.Loggers().Error("tcp send error：", err)
+		frame(output.framing, output.messageBuffer.Bytes(), &output.dataBuffer)
+		if !output.send(output.dataBuffer.Bytes()) {
+			logger.Loggers().Warn("tcp output stopped before the data was sent")
 			return
 		}
+		data.Ack()
 		output.sendMeter.Update(1)
 		output.recordTotalMetric.Incr(1)
 	}
 }
 
+// send writes data, reconnecting and retrying until it succeeds or the output is stopped.
+func (output *TCPOutput) send(data []byte) bool {
+	for {
+		if output.tcpConn != nil && time.Since(output.lastWrite) > idleCheckInterval && output.peerClosed() {
+			logger.Loggers().Warnf("tcp server closed the connection: %s", output.address())
+			output.disconnect()
+		}
+		if output.tcpConn == nil && !output.reconnect() {
+			return false
+		}
+		start := time.Now()
+		output.tcpConn.SetWriteDeadline(start.Add(output.writeTimeout))
+		_, err := output.tcpConn.Write(data)
+		if err == nil {
+			output.lastWrite = time.Now()
+			output.writeTimer.UpdateDuration(output.lastWrite.Sub(start))
+			return true
+		}
+		logger.Loggers().Error("tcp send error：", err)
+		output.sendErrorCounter.Incr(1)
+		output.disconnect()
+	}
+}
+
+// peerClosed detects a connection closed by the server while it was idle, a
+// write to it would succeed and the data would be lost.
+func (output *TCPOutput) peerClosed() bool {
+	var b [1]byte
+	output.tcpConn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := output.tcpConn.Read(b[:])
+	output.tcpConn.SetReadDeadline(time.Time{})
+	if err == nil {
+		return false
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return false
+	}
+	return true
+}
+
+// Stop waits for the queue to be drained, retries of an unreachable server
+// are abandoned once the queue is closed.
 func (output *TCPOutput) Stop() {
-	output.waitGroup.Wait()
-	if output.tcpConn != nil {
-		output.tcpConn.Close()
+	done := make(chan struct{})
+	go func() {
+		output.waitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(output.writeTimeout):
+		output.stopOnce.Do(func() {
+			close(output.stopChan)
+		})
+		<-done
 	}
+	output.disconnect()
 	logger.Loggers().Info("tcp output closed")
 }
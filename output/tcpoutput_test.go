@@ -0,0 +1,359 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPKI is a CA with a server certificate for 127.0.0.1 and a client
+// certificate, written as pem files.
+type testPKI struct {
+	dir        string
+	caPool     *x509.CertPool
+	caFile     string
+	serverCert tls.Certificate
+	clientCert string
+	clientKey  string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cleat-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cleat test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	p := &testPKI{dir: dir, caPool: x509.NewCertPool(), caFile: filepath.Join(dir, "ca.pem")}
+	p.caPool.AddCert(ca)
+	writePEM(t, p.caFile, "CERTIFICATE", caDER)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage, name string) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der, key
+	}
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth, "127.0.0.1")
+	p.serverCert = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth, "cleat")
+	p.clientCert = filepath.Join(dir, "client.pem")
+	p.clientKey = filepath.Join(dir, "client.key")
+	writePEM(t, p.clientCert, "CERTIFICATE", clientDER)
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, p.clientKey, "EC PRIVATE KEY", keyDER)
+	return p
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestTCPOutput(t *testing.T, conf *TCPOutputConfig, queue chan *event.Event) *TCPOutput {
+	t.Helper()
+	conf.Syslog.Format = FormatRaw
+	o, err := NewTCPOutput(conf, queue, metrics.NewMetricRegstry(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestTCPOutputTLSHandshake(t *testing.T) {
+	p := newTestPKI(t)
+	tests := []struct {
+		name       string
+		clientAuth tls.ClientAuthType
+		tls        TLSConfig
+		ok         bool
+	}{
+		{name: "server verified", clientAuth: tls.NoClientCert, tls: TLSConfig{CAFile: p.caFile}, ok: true},
+		{name: "mutual tls", clientAuth: tls.RequireAndVerifyClientCert,
+			tls: TLSConfig{CAFile: p.caFile, CertFile: p.clientCert, KeyFile: p.clientKey}, ok: true},
+		{name: "client certificate missing", clientAuth: tls.RequireAndVerifyClientCert, tls: TLSConfig{CAFile: p.caFile}},
+		{name: "unknown server ca", clientAuth: tls.NoClientCert, tls: TLSConfig{}},
+		{name: "wrong server name", clientAuth: tls.NoClientCert, tls: TLSConfig{CAFile: p.caFile, ServerName: "syslog.example.com"}},
+		{name: "insecure skip verify", clientAuth: tls.NoClientCert, tls: TLSConfig{InsecureSkipVerify: true}, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+				Certificates: []tls.Certificate{p.serverCert},
+				ClientAuth:   tt.clientAuth,
+				ClientCAs:    p.caPool,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			received := make(chan string, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				received <- line
+			}()
+			tlsConf := tt.tls
+			o := newTestTCPOutput(t, &TCPOutputConfig{
+				Server:     "127.0.0.1",
+				ServerPort: ln.Addr().(*net.TCPAddr).Port,
+				TLS:        &tlsConf,
+			}, make(chan *event.Event))
+			err = o.connect()
+			if err == nil {
+				//TLS 1.3的客户端证书在第一次读写时才被服务端校验
+				_, err = o.tcpConn.Write([]byte("hello\n"))
+				if err == nil {
+					select {
+					case line := <-received:
+						if line != "hello\n" {
+							err = io.ErrUnexpectedEOF
+						}
+					case <-time.After(5 * time.Second):
+						err = io.ErrNoProgress
+					}
+				}
+				o.disconnect()
+			}
+			if tt.ok && err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("handshake succeeded")
+			}
+		})
+	}
+}
+
+func TestFrame(t *testing.T) {
+	tests := []struct {
+		framing string
+		msg     string
+		want    string
+	}{
+		{FramingNewline, "hello", "hello\n"},
+		{FramingNewline, "hello\n", "hello\n"},
+		{FramingNewline, "", "\n"},
+		{FramingOctet, "hello", "5 hello"},
+		{FramingOctet, "a\nb", "3 a\nb"},
+		{FramingOctet, "日志", "6 日志"},
+		{FramingOctet, "", "0 "},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		frame(tt.framing, []byte(tt.msg), &buf)
+		if buf.String() != tt.want {
+			t.Errorf("frame(%s, %q) = %q, want %q", tt.framing, tt.msg, buf.String(), tt.want)
+		}
+	}
+}
+
+// readOctetFrame reads one RFC 6587 octet counted frame.
+func readOctetFrame(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+func TestTCPOutputOctetFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	messages := []string{"first", "multi\nline", "", "日志"}
+	queue := make(chan *event.Event, len(messages))
+	acker := &countAcker{}
+	for _, msg := range messages {
+		e := event.NewEvent("test", msg)
+		e.SetAcker(acker)
+		queue <- e
+	}
+	close(queue)
+	o := newTestTCPOutput(t, &TCPOutputConfig{Server: "127.0.0.1", ServerPort: ln.Addr().(*net.TCPAddr).Port, Framing: FramingOctet}, queue)
+	o.Start()
+	go o.Process()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range messages {
+		got, err := readOctetFrame(r)
+		if err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		if got != want {
+			t.Errorf("frame %q, want %q", got, want)
+		}
+	}
+	o.Stop()
+	if n := atomic.LoadInt64(&acker.acked); n != int64(len(messages)) {
+		t.Errorf("%d events acked, want %d", n, len(messages))
+	}
+}
+
+func TestRedialBackoff(t *testing.T) {
+	const failures = 6
+	backoffMin, backoffMax := 10*time.Millisecond, 40*time.Millisecond
+	counter := metrics.NewCounter("test-reconnect-total")
+	attempts := make([]time.Time, 0)
+	connect := func() error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) <= failures {
+			return io.ErrClosedPipe
+		}
+		return nil
+	}
+	start := time.Now()
+	if !redial(make(chan struct{}), backoffMin, backoffMax, counter, connect, "tcp", "test") {
+		t.Fatal("redial gave up")
+	}
+	if len(attempts) != failures+1 || counter.Value() != failures+1 {
+		t.Fatalf("%d attempts counted %d, want %d", len(attempts), counter.Value(), failures+1)
+	}
+	//每次等待backoff的一半到全部，backoff从backoffMin翻倍到backoffMax
+	backoff := backoffMin
+	last := start
+	for i, at := range attempts {
+		if delay := at.Sub(last); delay < backoff/2 {
+			t.Errorf("attempt %d after %v, want at least %v", i+1, delay, backoff/2)
+		}
+		last = at
+		if backoff *= 2; backoff > backoffMax {
+			backoff = backoffMax
+		}
+	}
+	if total := last.Sub(start); total > 2*time.Second {
+		t.Errorf("redial took %v, backoff not capped", total)
+	}
+
+	stopChan := make(chan struct{})
+	close(stopChan)
+	if redial(stopChan, time.Hour, time.Hour, counter, connect, "tcp", "test") {
+		t.Error("redial of a stopped output succeeded")
+	}
+}
+
+func TestTCPOutputReconnectsAndResends(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	queue := make(chan *event.Event, 10)
+	acker := &countAcker{}
+	send := func(msg string) {
+		e := event.NewEvent("test", msg)
+		e.SetAcker(acker)
+		queue <- e
+	}
+	o := newTestTCPOutput(t, &TCPOutputConfig{Server: "127.0.0.1", ServerPort: port, BackoffMin: 10 * time.Millisecond, BackoffMax: 50 * time.Millisecond}, queue)
+	//服务端还没有启动
+	o.Start()
+	go o.Process()
+	send("first")
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&acker.acked); n != 0 {
+		t.Fatalf("%d events acked without server", n)
+	}
+	if ln, err = net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port)); err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("received %q %v", line, err)
+	}
+	//服务端空闲时关闭连接，之后的数据在新连接上重发
+	conn.Close()
+	time.Sleep(idleCheckInterval + 100*time.Millisecond)
+	send("second")
+	conn, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "second\n" {
+		t.Fatalf("received %q %v after reconnect", line, err)
+	}
+	close(queue)
+	o.Stop()
+	if n := atomic.LoadInt64(&acker.acked); n != 2 {
+		t.Errorf("%d events acked, want 2", n)
+	}
+	if n := o.reconnectCounter.Value(); n < 2 {
+		t.Errorf("%d reconnects counted", n)
+	}
+}
@@ -0,0 +1,95 @@
+package output
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/lucky-abc/cleat/config"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSConfig is the tls block of the tcp output, TLS is on unless Enabled is
+// set to false. CertFile and KeyFile enable mutual TLS and CAFile replaces
+// the system roots for verifying the server.
+type TLSConfig struct {
+	Enabled            *bool    `mapstructure:"enabled"`
+	CAFile             string   `mapstructure:"caFile"`
+	CertFile           string   `mapstructure:"certFile"`
+	KeyFile            string   `mapstructure:"keyFile"`
+	ServerName         string   `mapstructure:"serverName"`
+	InsecureSkipVerify bool     `mapstructure:"insecureSkipVerify"`
+	MinVersion         string   `mapstructure:"minVersion"`
+	CipherSuites       []string `mapstructure:"cipherSuites"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func buildTLSConfig(c *TLSConfig, server string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = server
+	}
+	errs := &config.Errors{}
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(c.MinVersion), "tls")]
+		if !ok {
+			errs.Add("minVersion", "unknown tls version: %s", c.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			errs.Add("caFile", "%v", err)
+		} else {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				errs.Add("caFile", "no certificate found in %s", c.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	switch {
+	case c.CertFile == "" && c.KeyFile == "":
+	case c.CertFile == "":
+		errs.Add("certFile", "must be set together with keyFile")
+	case c.KeyFile == "":
+		errs.Add("keyFile", "must be set together with certFile")
+	default:
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			errs.Add("certFile", "load client certificate error: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(c.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		for _, s := range tls.InsecureCipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		for i, name := range c.CipherSuites {
+			id, ok := suites[strings.ToUpper(name)]
+			if !ok {
+				errs.Add(fmt.Sprintf("cipherSuites[%d]", i), "unknown tls cipher suite: %s", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+	if errs.Len() > 0 {
+		return nil, errs
+	}
+	return tlsConfig, nil
+}
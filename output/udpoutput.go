@@ -1,71 +1,149 @@
 package output
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
 	"net"
 	"sync"
+	"time"
 )
 
 type UDPOutput struct {
 	udpServer         string
 	udpServerPort     int
-	queue             chan string
+	queue             chan *event.Event
 	udpConn           *net.UDPConn
 	waitGroup         sync.WaitGroup
+	stopChan          chan struct{}
+	stopOnce          sync.Once
 	sendMeter         *metrics.Meter
 	recordTotalMetric *metrics.Counter
+	queueWaitTimer    *metrics.Timer
+	writeTimer        *metrics.Timer
+	reconnectCounter  *metrics.Counter
+	sendErrorCounter  *metrics.Counter
+	formatter         *syslogFormatter
+	dataBuffer        bytes.Buffer
+	stopTimeout       time.Duration
 }
 
-func NewUDPOutput(udpConfig *UDPOutputConfig, queue chan string, metricRegistry *metrics.MetricRegistry, tunnelName string) *UDPOutput {
+func NewUDPOutput(udpConfig *UDPOutputConfig, queue chan *event.Event, metricRegistry *metrics.MetricRegistry, name string) (*UDPOutput, error) {
+	formatter, err := newSyslogFormatter(udpConfig.Syslog)
+	if err != nil {
+		return nil, err
+	}
 	output := &UDPOutput{
+		formatter:     formatter,
 		udpServer:     udpConfig.Server,
 		udpServerPort: udpConfig.ServerPort,
 		queue:         queue,
+		stopChan:      make(chan struct{}),
+		stopTimeout:   defaultWriteTimeout,
 	}
-	sendMeter := metrics.NewMeter(tunnelName + "-udpoutput-rate")
+	sendMeter := metrics.NewMeter(name + "-udpoutput-rate")
 	metricRegistry.RegisterMetric(sendMeter)
 	output.sendMeter = sendMeter
-	output.recordTotalMetric = metricRegistry.GetCounter(tunnelName + "-output-record-total")
-	return output
+	recordTotalMetric := metrics.NewCounter(name + "-output-record-total")
+	metricRegistry.RegisterMetric(recordTotalMetric)
+	output.recordTotalMetric = recordTotalMetric
+	output.queueWaitTimer = metrics.NewTimer(name + "-output-queue-wait-time")
+	metricRegistry.RegisterMetric(output.queueWaitTimer)
+	output.writeTimer = metrics.NewTimer(name + "-output-write-time")
+	metricRegistry.RegisterMetric(output.writeTimer)
+	output.reconnectCounter = metrics.NewCounter(name + "-udpoutput-reconnect-total")
+	metricRegistry.RegisterMetric(output.reconnectCounter)
+	output.sendErrorCounter = metrics.NewCounter(name + "-udpoutput-send-error-total")
+	metricRegistry.RegisterMetric(output.sendErrorCounter)
+	return output, nil
+}
+
+func (output *UDPOutput) address() string {
+	return fmt.Sprintf("%s:%d", output.udpServer, output.udpServerPort)
 }
 
+// Start is called before Process, which Stop waits for.
 func (output *UDPOutput) Start() {
-	var udpAddr *net.UDPAddr
-	var err error
-	addrs := fmt.Sprintf("%s:%d", output.udpServer, output.udpServerPort)
-	logger.Loggers().Info("udp server address：", addrs)
-	udpAddr, err = net.ResolveUDPAddr("udp", addrs)
+	output.waitGroup.Add(1)
+	logger.Loggers().Info("udp server address：", output.address())
+	if err := output.connect(); err != nil {
+		logger.Loggers().Error("connect udp server error:", err)
+	}
+}
+
+func (output *UDPOutput) connect() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", output.address())
 	if err != nil {
-		logger.Loggers().Error("udp adress resolve error:", err)
-		return
+		return fmt.Errorf("udp adress resolve error: %v", err)
 	}
-	output.udpConn, err = net.DialUDP("udp", nil, udpAddr)
+	conn, err := net.DialUDP("udp", nil, udpAddr)
 	if err != nil {
-		logger.Loggers().Error("connect udp server error:", err)
-		return
+		return err
+	}
+	output.udpConn = conn
+	return nil
+}
+
+func (output *UDPOutput) disconnect() {
+	if output.udpConn != nil {
+		output.udpConn.Close()
+		output.udpConn = nil
 	}
 }
 
 func (output *UDPOutput) Process() {
-	output.waitGroup.Add(1)
 	defer output.waitGroup.Done()
 	for data := range output.queue {
-		_, err := output.udpConn.Write([]byte(data))
-		if err != nil {
-			logger.Loggers().Error("upd send error：", err)
+		output.queueWaitTimer.UpdateSince(data.Created())
+		output.dataBuffer.Reset()
+		output.formatter.Format(data, &output.dataBuffer)
+		if !output.send(output.dataBuffer.Bytes()) {
+			logger.Loggers().Warn("udp output stopped before the data was sent")
 			return
 		}
+		data.Ack()
 		output.sendMeter.Update(1)
 		output.recordTotalMetric.Incr(1)
 	}
 }
 
+// send writes data, dialing again after an error, e.g. the port unreachable
+// reported for the previous datagram, until it succeeds or the output is stopped.
+func (output *UDPOutput) send(data []byte) bool {
+	for {
+		if output.udpConn == nil && !redial(output.stopChan, defaultBackoffMin, defaultBackoffMax, output.reconnectCounter, output.connect, "udp", output.address()) {
+			return false
+		}
+		start := time.Now()
+		_, err := output.udpConn.Write(data)
+		if err == nil {
+			output.writeTimer.UpdateSince(start)
+			return true
+		}
+		logger.Loggers().Error("upd send error：", err)
+		output.sendErrorCounter.Incr(1)
+		output.disconnect()
+	}
+}
+
+// Stop waits for the queue to be drained, retries of an unreachable server
+// are abandoned once the queue is closed.
 func (output *UDPOutput) Stop() {
-	output.waitGroup.Wait()
-	if output.udpConn != nil {
-		output.udpConn.Close()
+	done := make(chan struct{})
+	go func() {
+		output.waitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(output.stopTimeout):
+		output.stopOnce.Do(func() {
+			close(output.stopChan)
+		})
+		<-done
 	}
+	output.disconnect()
 	logger.Loggers().Info("udp output closed")
 }
@@ -0,0 +1,94 @@
+package output
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countAcker struct {
+	acked int64
+}
+
+func (a *countAcker) Ack(cp event.Checkpoint) {
+	atomic.AddInt64(&a.acked, 1)
+}
+
+func newTestUDPOutput(t *testing.T, port int, queue chan *event.Event) *UDPOutput {
+	t.Helper()
+	o, err := NewUDPOutput(&UDPOutputConfig{Server: "127.0.0.1", ServerPort: port, Syslog: SyslogConfig{Format: FormatRaw}},
+		queue, metrics.NewMetricRegstry(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestUDPOutputDialsWhenStartFailed(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	queue := make(chan *event.Event, 3)
+	o := newTestUDPOutput(t, conn.LocalAddr().(*net.UDPAddr).Port, queue)
+	acker := &countAcker{}
+	for _, msg := range []string{"a", "b", "c"} {
+		e := event.NewEvent("test", msg)
+		e.SetAcker(acker)
+		queue <- e
+	}
+	close(queue)
+	//模拟Start连接失败，连接为空时发送前重新连接
+	o.Start()
+	o.disconnect()
+	go o.Process()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	var got []string
+	for len(got) < 3 {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read datagram: %v, got %v", err, got)
+		}
+		got = append(got, strings.TrimSpace(string(buf[:n])))
+	}
+	o.Stop()
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("got %v", got)
+	}
+	if n := atomic.LoadInt64(&acker.acked); n != 3 {
+		t.Errorf("%d events acked, want 3", n)
+	}
+}
+
+func TestUDPOutputStopUnreachable(t *testing.T) {
+	queue := make(chan *event.Event, 1)
+	o := newTestUDPOutput(t, 70000, queue)
+	o.stopTimeout = 100 * time.Millisecond
+	o.Start()
+	acker := &countAcker{}
+	e := event.NewEvent("test", "lost")
+	e.SetAcker(acker)
+	queue <- e
+	close(queue)
+	go o.Process()
+	time.Sleep(10 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		o.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop blocked on an unreachable server")
+	}
+	if n := atomic.LoadInt64(&acker.acked); n != 0 {
+		t.Errorf("unsent event acked")
+	}
+}
@@ -0,0 +1,368 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/spf13/cast"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition is a parsed boolean expression over an event, e.g.
+//
+//	level == "DEBUG" and not (message =~ "^GET /health" or bytes > 1024)
+//
+// Operands are field names, quoted strings or numbers. message, host and
+// source refer to the event itself, any other name to a field. A field on
+// its own is true when it exists and is not empty.
+type Condition interface {
+	Match(e *event.Event) bool
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string
+	pos   int
+}
+
+func ParseCondition(s string) (Condition, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return c, nil
+}
+
+func tokenize(s string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[start:i]), value: sb.String(), pos: start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: text, pos: start})
+		case unicode.IsLetter(r) || r == '_' || r == '@':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_@.-", runes[i])) {
+				i++
+			}
+			text := string(runes[start:i])
+			switch strings.ToLower(text) {
+			case "and", "or", "not", "contains":
+				tokens = append(tokens, token{kind: tokenOperator, text: text, value: strings.ToLower(text), pos: start})
+			default:
+				tokens = append(tokens, token{kind: tokenIdent, text: text, value: text, pos: start})
+			}
+		default:
+			start := i
+			op := ""
+			for _, candidate := range []string{"==", "!=", "=~", "!~", "<=", ">=", "&&", "||", "<", ">", "!"} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", string(r), i)
+			}
+			i += len(op)
+			switch op {
+			case "&&":
+				op = "and"
+			case "||":
+				op = "or"
+			case "!":
+				op = "not"
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: string(runes[start:i]), value: op, pos: start})
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, text: "end", pos: len(runes)}), nil
+}
+
+type conditionParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *conditionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *conditionParser) isOperator(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && t.value == op
+}
+
+func (p *conditionParser) parseOr() (Condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (Condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseNot() (Condition, error) {
+	if p.isOperator("not") {
+		p.next()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notCondition{c: c}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *conditionParser) parsePrimary() (Condition, error) {
+	t := p.peek()
+	if t.kind == tokenLParen {
+		p.next()
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ) at %d", t.pos)
+		}
+		return c, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	if op.kind != tokenOperator || op.value == "and" || op.value == "or" || op.value == "not" {
+		field, ok := left.(fieldOperand)
+		if !ok {
+			return nil, fmt.Errorf("expected an operator after %q at %d", t.text, t.pos)
+		}
+		return &existsCondition{field: field}, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	c := &compareCondition{op: op.value, left: left, right: right}
+	if op.value == "=~" || op.value == "!~" {
+		literal, ok := right.(literalOperand)
+		if !ok {
+			return nil, fmt.Errorf("%s expects a quoted regular expression at %d", op.text, op.pos)
+		}
+		if c.re, err = regexp.Compile(string(literal)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *conditionParser) parseOperand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenIdent:
+		return fieldOperand(t.value), nil
+	case tokenString, tokenNumber:
+		return literalOperand(t.value), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+type operand interface {
+	value(e *event.Event) (string, bool)
+}
+
+type fieldOperand string
+
+func (f fieldOperand) value(e *event.Event) (string, bool) {
+	switch string(f) {
+	case "message":
+		return e.Message, true
+	case "host":
+		return e.Host, true
+	case "source":
+		return e.Source, true
+	}
+	v, ok := e.GetField(string(f))
+	if !ok {
+		return "", false
+	}
+	return cast.ToString(v), true
+}
+
+type literalOperand string
+
+func (l literalOperand) value(e *event.Event) (string, bool) {
+	return string(l), true
+}
+
+type andCondition struct {
+	left, right Condition
+}
+
+func (c *andCondition) Match(e *event.Event) bool {
+	return c.left.Match(e) && c.right.Match(e)
+}
+
+type orCondition struct {
+	left, right Condition
+}
+
+func (c *orCondition) Match(e *event.Event) bool {
+	return c.left.Match(e) || c.right.Match(e)
+}
+
+type notCondition struct {
+	c Condition
+}
+
+func (c *notCondition) Match(e *event.Event) bool {
+	return !c.c.Match(e)
+}
+
+type existsCondition struct {
+	field fieldOperand
+}
+
+func (c *existsCondition) Match(e *event.Event) bool {
+	v, ok := c.field.value(e)
+	return ok && v != ""
+}
+
+type compareCondition struct {
+	op          string
+	left, right operand
+	re          *regexp.Regexp
+}
+
+// Match compares numerically when both sides are numbers, a missing field
+// only satisfies != and !~.
+func (c *compareCondition) Match(e *event.Event) bool {
+	l, lok := c.left.value(e)
+	r, rok := c.right.value(e)
+	if !lok || !rok {
+		return c.op == "!=" || c.op == "!~"
+	}
+	switch c.op {
+	case "=~":
+		return c.re.MatchString(l)
+	case "!~":
+		return !c.re.MatchString(l)
+	case "contains":
+		return strings.Contains(l, r)
+	}
+	lf, lerr := strconv.ParseFloat(l, 64)
+	rf, rerr := strconv.ParseFloat(r, 64)
+	numeric := lerr == nil && rerr == nil
+	switch c.op {
+	case "==":
+		if numeric {
+			return lf == rf
+		}
+		return l == r
+	case "!=":
+		if numeric {
+			return lf != rf
+		}
+		return l != r
+	}
+	if !numeric {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return lf < rf
+	case "<=":
+		return lf <= rf
+	case ">":
+		return lf > rf
+	case ">=":
+		return lf >= rf
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"testing"
+)
+
+func newConditionEvent() *event.Event {
+	e := event.NewEvent("/var/log/app.log", "GET /health 200")
+	e.Host = "web01"
+	e.SetField("level", "DEBUG")
+	e.SetField("bytes", 2048)
+	e.SetField("status", "200")
+	e.SetField("version", "10.0")
+	e.SetField("name", "abc")
+	e.SetField("empty", "")
+	return e
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		match     bool
+	}{
+		// precedence: not > and > or
+		{`level == "INFO" and bytes > 1 or status == 200`, true},
+		{`level == "INFO" and (bytes > 1 or status == 200)`, false},
+		{`status == 200 or level == "INFO" and bytes < 1`, true},
+		{`not level == "INFO" and bytes > 1`, true},
+		{`not (level == "DEBUG" and bytes > 1)`, false},
+		{`not not level == "DEBUG"`, true},
+		{`level == "DEBUG" && !(bytes < 1 || status != 200)`, true},
+		{`level == "debug" AND bytes > 1`, false},
+		// numbers compare by value, the rest as strings
+		{`bytes > 1024`, true},
+		{`bytes > 999`, true},
+		{`bytes >= 2048 and bytes <= 2048`, true},
+		{`bytes < -1`, false},
+		{`version == 10`, true},
+		{`version != 10`, false},
+		{`status == "200"`, true},
+		{`name == "abc"`, true},
+		{`name != "abd"`, true},
+		{`name < "abd"`, false},
+		{`name > 1`, false},
+		// regular expressions and contains
+		{`message =~ "^GET /health"`, true},
+		{`message !~ '^POST'`, true},
+		{`message contains "health"`, true},
+		{`host =~ "^web\\d+$"`, true},
+		{`source contains "app.log"`, true},
+		// missing and empty fields
+		{`missing == "x"`, false},
+		{`missing != "x"`, true},
+		{`missing !~ "x"`, true},
+		{`missing =~ ".*"`, false},
+		{`missing > 1`, false},
+		{`missing`, false},
+		{`empty`, false},
+		{`level`, true},
+		{`not missing`, true},
+	}
+	e := newConditionEvent()
+	for _, tt := range tests {
+		c, err := ParseCondition(tt.condition)
+		if err != nil {
+			t.Errorf("ParseCondition(%s): %v", tt.condition, err)
+			continue
+		}
+		if got := c.Match(e); got != tt.match {
+			t.Errorf("%s matched %v, want %v", tt.condition, got, tt.match)
+		}
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	for _, condition := range []string{
+		``,
+		`level ==`,
+		`(level == "DEBUG"`,
+		`level == "DEBUG")`,
+		`level == "DEBUG`,
+		`"DEBUG"`,
+		`level =~ other`,
+		`message =~ "("`,
+		`level = "DEBUG"`,
+		`level == "DEBUG" and`,
+		`level "DEBUG"`,
+	} {
+		if _, err := ParseCondition(condition); err == nil {
+			t.Errorf("ParseCondition(%s) accepted", condition)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+)
+
+func init() {
+	RegisterProcessor("fields", func(conf interface{}) (interface{}, error) {
+		fieldsConfig := &FieldsConfig{}
+		if err := config.Decode(conf, fieldsConfig); err != nil {
+			return nil, err
+		}
+		return fieldsConfig, nil
+	}, func(conf interface{}, metricRegistry *metrics.MetricRegistry, name string) (Processor, error) {
+		return NewFieldsProcessor(conf.(*FieldsConfig)), nil
+	})
+}
+
+type FieldsConfig struct {
+	Add    map[string]interface{} `mapstructure:"add"`
+	Rename map[string]string      `mapstructure:"rename"`
+	Remove []string               `mapstructure:"remove"`
+}
+
+// FieldsProcessor adds, renames and removes event fields.
+type FieldsProcessor struct {
+	add    map[string]interface{}
+	rename map[string]string
+	remove []string
+}
+
+func NewFieldsProcessor(conf *FieldsConfig) *FieldsProcessor {
+	return &FieldsProcessor{
+		add:    conf.Add,
+		rename: conf.Rename,
+		remove: conf.Remove,
+	}
+}
+
+func (p *FieldsProcessor) Process(e *event.Event) []*event.Event {
+	for from, to := range p.rename {
+		if v, ok := e.GetField(from); ok {
+			delete(e.Fields, from)
+			e.SetField(to, v)
+		}
+	}
+	for _, k := range p.remove {
+		delete(e.Fields, k)
+	}
+	for k, v := range p.add {
+		e.SetField(k, v)
+	}
+	return []*event.Event{e}
+}
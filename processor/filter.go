@@ -0,0 +1,74 @@
+package processor
+
+import (
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+const (
+	FilterModeDrop    = "drop"
+	FilterModeInclude = "include"
+)
+
+func init() {
+	RegisterProcessor("filter", parseFilterConfig, func(conf interface{}, metricRegistry *metrics.MetricRegistry, name string) (Processor, error) {
+		return NewFilterProcessor(conf.(*FilterConfig))
+	})
+}
+
+type FilterConfig struct {
+	Condition string `mapstructure:"condition"`
+	Mode      string `mapstructure:"mode"`
+}
+
+func parseFilterConfig(conf interface{}) (interface{}, error) {
+	filterConfig := &FilterConfig{}
+	if err := config.Decode(conf, filterConfig); err != nil {
+		return nil, err
+	}
+	errs := &config.Errors{}
+	if _, err := ParseCondition(filterConfig.Condition); err != nil {
+		errs.Add("condition", "%v", err)
+	}
+	filterConfig.Mode = strings.ToLower(filterConfig.Mode)
+	switch filterConfig.Mode {
+	case "", FilterModeDrop, FilterModeInclude:
+	default:
+		errs.Add("mode", "must be %s or %s", FilterModeDrop, FilterModeInclude)
+	}
+	return filterConfig, errs.Err()
+}
+
+// FilterProcessor drops the events matching its condition, or in include
+// mode the ones that do not match. The dropped events are counted by the
+// pipeline in <name>-drop-total.
+type FilterProcessor struct {
+	condition Condition
+	include   bool
+}
+
+func NewFilterProcessor(conf *FilterConfig) (*FilterProcessor, error) {
+	condition, err := ParseCondition(conf.Condition)
+	if err != nil {
+		return nil, errors.Wrap(err, "filter condition")
+	}
+	p := &FilterProcessor{condition: condition}
+	switch strings.ToLower(conf.Mode) {
+	case "", FilterModeDrop:
+	case FilterModeInclude:
+		p.include = true
+	default:
+		return nil, errors.Errorf("filter mode must be %s or %s", FilterModeDrop, FilterModeInclude)
+	}
+	return p, nil
+}
+
+func (p *FilterProcessor) Process(e *event.Event) []*event.Event {
+	if p.condition.Match(e) == p.include {
+		return []*event.Event{e}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGrokField       = "message"
+	defaultGrokFailureTag  = "_grokparsefailure"
+	grokMaxReferenceDepth  = 32
+	grokCaptureGroupPrefix = "grok"
+	grokConvertInt         = "int"
+	grokConvertFloat       = "float"
+	tagsField              = "tags"
+)
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(int|float))?\}`)
+
+func init() {
+	RegisterProcessor("grok", parseGrokConfig, func(conf interface{}, metricRegistry *metrics.MetricRegistry, name string) (Processor, error) {
+		return NewGrokProcessor(conf.(*GrokConfig), metricRegistry, name)
+	})
+}
+
+// GrokConfig lists the patterns tried in order, Pattern is a shorthand for a
+// single one and goes first.
+type GrokConfig struct {
+	Field              string            `mapstructure:"field"`
+	Pattern            string            `mapstructure:"pattern"`
+	Patterns           []string          `mapstructure:"patterns"`
+	PatternDefinitions map[string]string `mapstructure:"patternDefinitions"`
+	BreakOnMatch       bool              `mapstructure:"breakOnMatch"`
+	TagOnFailure       string            `mapstructure:"tagOnFailure"`
+}
+
+func parseGrokConfig(conf interface{}) (interface{}, error) {
+	grokConfig := &GrokConfig{
+		Field:        defaultGrokField,
+		BreakOnMatch: true,
+		TagOnFailure: defaultGrokFailureTag,
+	}
+	if err := config.Decode(conf, grokConfig); err != nil {
+		return nil, err
+	}
+	if grokConfig.Field == "" {
+		grokConfig.Field = defaultGrokField
+	}
+	if _, err := grokConfig.compile(); err != nil {
+		return nil, err
+	}
+	return grokConfig, nil
+}
+
+// compile reports the pattern errors by key, the pattern shorthand is the
+// first of the returned patterns.
+func (c *GrokConfig) compile() ([]*grokPattern, error) {
+	definitions := make(map[string]string, len(grokPatterns))
+	for k, v := range grokPatterns {
+		definitions[k] = v
+	}
+	for k, v := range c.PatternDefinitions {
+		definitions[strings.ToUpper(k)] = v
+	}
+	errs := &config.Errors{}
+	patterns := make([]*grokPattern, 0, len(c.Patterns)+1)
+	compile := func(key string, source string) {
+		gp, err := compileGrok(source, definitions)
+		if err != nil {
+			errs.Add(key, "%v", err)
+			return
+		}
+		patterns = append(patterns, gp)
+	}
+	if c.Pattern != "" {
+		compile("pattern", c.Pattern)
+	}
+	for i, source := range c.Patterns {
+		compile(fmt.Sprintf("patterns[%d]", i), source)
+	}
+	if c.Pattern == "" && len(c.Patterns) == 0 {
+		errs.Add("patterns", "grok patterns is empty")
+	}
+	if errs.Len() > 0 {
+		return nil, errs
+	}
+	return patterns, nil
+}
+
+type grokCapture struct {
+	field   string
+	convert string
+}
+
+type grokPattern struct {
+	regexp     *regexp.Regexp
+	captures   map[string]*grokCapture
+	matchMeter *metrics.Meter
+}
+
+// GrokProcessor matches a field against grok patterns (%{NAME:field:type})
+// or regular expressions with named groups, the captures become fields.
+type GrokProcessor struct {
+	field          string
+	patterns       []*grokPattern
+	breakOnMatch   bool
+	tagOnFailure   string
+	failureCounter *metrics.Counter
+}
+
+func NewGrokProcessor(conf *GrokConfig, metricRegistry *metrics.MetricRegistry, name string) (*GrokProcessor, error) {
+	p := &GrokProcessor{
+		field:        conf.Field,
+		breakOnMatch: conf.BreakOnMatch,
+		tagOnFailure: conf.TagOnFailure,
+	}
+	if p.field == "" {
+		p.field = defaultGrokField
+	}
+	patterns, err := conf.compile()
+	if err != nil {
+		return nil, err
+	}
+	for i, gp := range patterns {
+		patternName := fmt.Sprintf("%s-pattern[%d]", name, i)
+		metricRegistry.RegisterLabels(patternName, "grok-pattern", "pattern", strconv.Itoa(i))
+		gp.matchMeter = metrics.NewMeter(patternName + "-match-rate")
+		metricRegistry.RegisterMetric(gp.matchMeter)
+		p.patterns = append(p.patterns, gp)
+	}
+	p.failureCounter = metrics.NewCounter(name + "-failure-total")
+	metricRegistry.RegisterMetric(p.failureCounter)
+	return p, nil
+}
+
+func compileGrok(source string, definitions map[string]string) (*grokPattern, error) {
+	gp := &grokPattern{captures: make(map[string]*grokCapture)}
+	expr, err := expandGrok(source, definitions, gp.captures, 0)
+	if err != nil {
+		return nil, err
+	}
+	//兼容(?<name>...)写法
+	expr = strings.Replace(expr, "(?<", "(?P<", -1)
+	gp.regexp, err = regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, groupName := range gp.regexp.SubexpNames() {
+		if groupName != "" && gp.captures[groupName] == nil {
+			gp.captures[groupName] = &grokCapture{field: groupName}
+		}
+	}
+	return gp, nil
+}
+
+// expandGrok replaces the pattern references recursively, the named ones
+// become capture groups recorded in captures.
+func expandGrok(source string, definitions map[string]string, captures map[string]*grokCapture, depth int) (string, error) {
+	if depth > grokMaxReferenceDepth {
+		return "", errors.New("grok pattern references are too deep")
+	}
+	var expandErr error
+	expr := grokReference.ReplaceAllStringFunc(source, func(ref string) string {
+		if expandErr != nil {
+			return ""
+		}
+		m := grokReference.FindStringSubmatch(ref)
+		definition, ok := definitions[m[1]]
+		if !ok {
+			expandErr = fmt.Errorf("unknown grok pattern: %s", m[1])
+			return ""
+		}
+		sub, err := expandGrok(definition, definitions, captures, depth+1)
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+		if m[2] == "" {
+			return "(?:" + sub + ")"
+		}
+		groupName := fmt.Sprintf("%s%d", grokCaptureGroupPrefix, len(captures))
+		captures[groupName] = &grokCapture{field: m[2], convert: m[3]}
+		return "(?P<" + groupName + ">" + sub + ")"
+	})
+	return expr, expandErr
+}
+
+func (p *GrokProcessor) Process(e *event.Event) []*event.Event {
+	value := e.Message
+	if p.field != defaultGrokField {
+		v, ok := e.GetField(p.field)
+		if !ok {
+			p.fail(e)
+			return []*event.Event{e}
+		}
+		value = cast.ToString(v)
+	}
+	matched := false
+	for _, gp := range p.patterns {
+		if !gp.match(e, value) {
+			continue
+		}
+		matched = true
+		if p.breakOnMatch {
+			break
+		}
+	}
+	if !matched {
+		p.fail(e)
+	}
+	return []*event.Event{e}
+}
+
+func (gp *grokPattern) match(e *event.Event, value string) bool {
+	loc := gp.regexp.FindStringSubmatchIndex(value)
+	if loc == nil {
+		return false
+	}
+	gp.matchMeter.Update(1)
+	for i, groupName := range gp.regexp.SubexpNames() {
+		c := gp.captures[groupName]
+		if c == nil || loc[2*i] < 0 {
+			continue
+		}
+		capture := value[loc[2*i]:loc[2*i+1]]
+		var v interface{} = capture
+		switch c.convert {
+		case grokConvertInt:
+			if n, err := strconv.ParseInt(capture, 10, 64); err == nil {
+				v = n
+			}
+		case grokConvertFloat:
+			if f, err := strconv.ParseFloat(capture, 64); err == nil {
+				v = f
+			}
+		}
+		e.SetField(c.field, v)
+	}
+	return true
+}
+
+func (p *GrokProcessor) fail(e *event.Event) {
+	p.failureCounter.Incr(1)
+	if p.tagOnFailure != "" {
+		addTag(e, p.tagOnFailure)
+	}
+}
+
+// addTag appends tag to the comma separated tags field.
+func addTag(e *event.Event, tag string) {
+	tags := cast.ToString(e.Fields[tagsField])
+	if tags == "" {
+		e.SetField(tagsField, tag)
+		return
+	}
+	for _, t := range strings.Split(tags, ",") {
+		if t == tag {
+			return
+		}
+	}
+	e.SetField(tagsField, tags+","+tag)
+}
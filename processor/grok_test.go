@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestGrok(t *testing.T, section map[string]interface{}) *GrokProcessor {
+	t.Helper()
+	conf, err := parseGrokConfig(section)
+	if err != nil {
+		t.Fatalf("parse grok config: %v", err)
+	}
+	p, err := NewGrokProcessor(conf.(*GrokConfig), metrics.NewMetricRegstry(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGrokProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		section map[string]interface{}
+		message string
+		fields  map[string]interface{}
+	}{
+		{
+			name:    "nested references and typed captures",
+			section: map[string]interface{}{"pattern": `%{IP:client} %{WORD:method} %{URIPATHPARAM:path} %{NUMBER:bytes:int} %{NUMBER:duration:float}`},
+			message: "10.0.0.1 GET /index.html?a=1 2048 0.25",
+			fields: map[string]interface{}{
+				"client": "10.0.0.1", "method": "GET", "path": "/index.html?a=1",
+				"bytes": int64(2048), "duration": 0.25,
+			},
+		},
+		{
+			name:    "conversion failure keeps the string",
+			section: map[string]interface{}{"pattern": `%{NUMBER:n:int}`},
+			message: "1.5",
+			fields:  map[string]interface{}{"n": "1.5"},
+		},
+		{
+			name:    "unnamed reference captures nothing",
+			section: map[string]interface{}{"pattern": `%{LOGLEVEL} %{GREEDYDATA:msg}`},
+			message: "ERROR disk full",
+			fields:  map[string]interface{}{"msg": "disk full"},
+		},
+		{
+			name: "custom definitions",
+			section: map[string]interface{}{
+				"pattern":            `%{APPID:app} %{LOGLEVEL:level}`,
+				"patternDefinitions": map[string]interface{}{"appid": `%{APPPREFIX}-\d+`, "APPPREFIX": `[A-Z]{3}`},
+			},
+			message: "ABC-42 WARN",
+			fields:  map[string]interface{}{"app": "ABC-42", "level": "WARN"},
+		},
+		{
+			name:    "named groups",
+			section: map[string]interface{}{"pattern": `^(?<level>[A-Z]+) (?P<msg>.*)$`},
+			message: "INFO started",
+			fields:  map[string]interface{}{"level": "INFO", "msg": "started"},
+		},
+		{
+			name:    "first match wins",
+			section: map[string]interface{}{"patterns": []interface{}{`%{INT:code:int}`, `%{WORD:word}`}},
+			message: "404",
+			fields:  map[string]interface{}{"code": int64(404)},
+		},
+		{
+			name:    "all patterns without breakOnMatch",
+			section: map[string]interface{}{"patterns": []interface{}{`^%{INT:code:int}`, `%{WORD:word}$`}, "breakOnMatch": false},
+			message: "404 missing",
+			fields:  map[string]interface{}{"code": int64(404), "word": "missing"},
+		},
+		{
+			name:    "failure tag",
+			section: map[string]interface{}{"pattern": `^%{INT:code}$`},
+			message: "not a number",
+			fields:  map[string]interface{}{tagsField: defaultGrokFailureTag},
+		},
+		{
+			name:    "other field",
+			section: map[string]interface{}{"pattern": `%{INT:code:int}`, "field": "raw", "tagOnFailure": "nocode"},
+			message: "42",
+			fields:  map[string]interface{}{tagsField: "nocode"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestGrok(t, tt.section)
+			e := event.NewEvent("test", tt.message)
+			out := p.Process(e)
+			if len(out) != 1 || out[0] != e {
+				t.Fatalf("grok returned %v", out)
+			}
+			if !reflect.DeepEqual(e.Fields, tt.fields) {
+				t.Errorf("fields %#v, want %#v", e.Fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestGrokConfigErrors(t *testing.T) {
+	tests := []struct {
+		section map[string]interface{}
+		want    string
+	}{
+		{map[string]interface{}{"patterns": []interface{}{`%{WORD:w}`, `%{NOPE:x}`}}, "patterns[1]: unknown grok pattern: NOPE"},
+		{map[string]interface{}{"pattern": `%{LOOP}`, "patternDefinitions": map[string]interface{}{"LOOP": `a%{LOOP}`}}, "pattern: grok pattern references are too deep"},
+		{map[string]interface{}{"pattern": `(unclosed`}, "pattern: "},
+		{map[string]interface{}{}, "patterns: grok patterns is empty"},
+	}
+	for _, tt := range tests {
+		_, err := parseGrokConfig(tt.section)
+		if err == nil {
+			t.Errorf("%v accepted", tt.section)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("error %q, want %q", err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"sort"
+	"sync"
+)
+
+// Processor handles one event at a time, it may modify e and returns the
+// events to pass on: nil drops e, e.Split builds extra events out of it.
+type Processor interface {
+	Process(e *event.Event) []*event.Event
+}
+
+// Parser decodes and checks the raw config section of a processor type, the
+// errors are reported as a *config.Errors with key paths relative to the section.
+type Parser func(conf interface{}) (interface{}, error)
+
+// Builder creates a processor of one type from the config returned by its
+// Parser, name is unique per processor and prefixes its metrics.
+type Builder func(conf interface{}, metricRegistry *metrics.MetricRegistry, name string) (Processor, error)
+
+type registration struct {
+	parser  Parser
+	builder Builder
+}
+
+var (
+	buildersMutex sync.RWMutex
+	builders      = make(map[string]registration)
+)
+
+func RegisterProcessor(processorType string, parser Parser, builder Builder) {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+	builders[processorType] = registration{parser: parser, builder: builder}
+}
+
+func lookup(processorType string) (registration, error) {
+	buildersMutex.RLock()
+	r, ok := builders[processorType]
+	buildersMutex.RUnlock()
+	if !ok {
+		return r, fmt.Errorf("unknown processor type: %s", processorType)
+	}
+	return r, nil
+}
+
+func ParseProcessor(processorType string, conf interface{}) (interface{}, error) {
+	r, err := lookup(processorType)
+	if err != nil {
+		return nil, err
+	}
+	return r.parser(conf)
+}
+
+func BuildProcessor(processorType string, conf interface{}, metricRegistry *metrics.MetricRegistry, name string) (Processor, error) {
+	r, err := lookup(processorType)
+	if err != nil {
+		return nil, err
+	}
+	return r.builder(conf, metricRegistry, name)
+}
+
+func ProcessorTypes() []string {
+	buildersMutex.RLock()
+	defer buildersMutex.RUnlock()
+	types := make([]string, 0, len(builders))
+	for t := range builders {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+type stage struct {
+	processor  Processor
+	inMetric   *metrics.Counter
+	outMetric  *metrics.Counter
+	dropMetric *metrics.Counter
+}
+
+// Pipeline runs the processors of a tunnel in order.
+type Pipeline struct {
+	stages []*stage
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+func (p *Pipeline) Add(name string, processor Processor, metricRegistry *metrics.MetricRegistry) {
+	s := &stage{
+		processor:  processor,
+		inMetric:   metrics.NewCounter(name + "-in-total"),
+		outMetric:  metrics.NewCounter(name + "-out-total"),
+		dropMetric: metrics.NewCounter(name + "-drop-total"),
+	}
+	metricRegistry.RegisterMetric(s.inMetric)
+	metricRegistry.RegisterMetric(s.outMetric)
+	metricRegistry.RegisterMetric(s.dropMetric)
+	p.stages = append(p.stages, s)
+}
+
+func (p *Pipeline) Len() int {
+	return len(p.stages)
+}
+
+// Process passes e through every processor, the dropped events are
+// acknowledged so that their checkpoints still move forward.
+func (p *Pipeline) Process(e *event.Event) []*event.Event {
+	events := []*event.Event{e}
+	for _, s := range p.stages {
+		next := make([]*event.Event, 0, len(events))
+		for _, in := range events {
+			s.inMetric.Incr(1)
+			out := s.processor.Process(in)
+			kept := false
+			for _, o := range out {
+				if o == in {
+					kept = true
+				}
+			}
+			if len(out) == 0 {
+				s.dropMetric.Incr(1)
+			}
+			if !kept {
+				in.Ack()
+			}
+			s.outMetric.Incr(int64(len(out)))
+			next = append(next, out...)
+		}
+		events = next
+	}
+	return events
+}
@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"reflect"
+	"testing"
+)
+
+type recordAcker struct {
+	acked []uint64
+}
+
+func (a *recordAcker) Ack(cp event.Checkpoint) {
+	a.acked = append(a.acked, cp.Offset)
+}
+
+func newTestPipeline(t *testing.T) (*Pipeline, *metrics.MetricRegistry) {
+	t.Helper()
+	mr := metrics.NewMetricRegstry()
+	p := NewPipeline()
+	p.Add("split", NewSplitProcessor(&SplitConfig{}), mr)
+	filter, err := NewFilterProcessor(&FilterConfig{Condition: `message contains "DEBUG"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Add("filter", filter, mr)
+	return p, mr
+}
+
+func newAckedEvent(acker *recordAcker, message string, offset uint64) *event.Event {
+	e := event.NewEvent("test", message)
+	e.Checkpoint = event.Checkpoint{Key: "test", Offset: offset}
+	e.SetAcker(acker)
+	return e
+}
+
+func TestPipelineAcks(t *testing.T) {
+	p, mr := newTestPipeline(t)
+	acker := &recordAcker{}
+
+	//整条被丢弃的数据立即确认
+	if out := p.Process(newAckedEvent(acker, "DEBUG dropped", 1)); len(out) != 0 {
+		t.Fatalf("dropped event passed: %v", out)
+	}
+	if !reflect.DeepEqual(acker.acked, []uint64{1}) {
+		t.Fatalf("acked %v after drop", acker.acked)
+	}
+
+	//拆分后的数据全部确认后才确认原数据，被丢弃的部分不用等待
+	out := p.Process(newAckedEvent(acker, "INFO a\nDEBUG b\n\nINFO c", 2))
+	messages := make([]string, 0)
+	for _, e := range out {
+		messages = append(messages, e.Message)
+	}
+	if !reflect.DeepEqual(messages, []string{"INFO a", "INFO c"}) {
+		t.Fatalf("split into %q", messages)
+	}
+	out[1].Ack()
+	if len(acker.acked) != 1 {
+		t.Fatalf("acked %v before all parts", acker.acked)
+	}
+	out[0].Ack()
+	if !reflect.DeepEqual(acker.acked, []uint64{1, 2}) {
+		t.Fatalf("acked %v after all parts", acker.acked)
+	}
+
+	//只有空行的数据拆分后被丢弃
+	if out := p.Process(newAckedEvent(acker, "\n\n", 3)); len(out) != 0 {
+		t.Fatalf("empty parts passed: %v", out)
+	}
+	if !reflect.DeepEqual(acker.acked, []uint64{1, 2, 3}) {
+		t.Fatalf("acked %v after empty split", acker.acked)
+	}
+
+	for name, want := range map[string]int64{
+		"split-in-total": 3, "split-out-total": 4, "split-drop-total": 1,
+		"filter-in-total": 4, "filter-out-total": 2, "filter-drop-total": 2,
+	} {
+		if got := mr.GetCounter(name).Value(); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"strings"
+)
+
+func init() {
+	RegisterProcessor("split", func(conf interface{}) (interface{}, error) {
+		splitConfig := &SplitConfig{}
+		if err := config.Decode(conf, splitConfig); err != nil {
+			return nil, err
+		}
+		return splitConfig, nil
+	}, func(conf interface{}, metricRegistry *metrics.MetricRegistry, name string) (Processor, error) {
+		return NewSplitProcessor(conf.(*SplitConfig)), nil
+	})
+}
+
+// SplitConfig sets the separator of the parts, a line feed by default.
+type SplitConfig struct {
+	Separator string `mapstructure:"separator"`
+}
+
+// SplitProcessor turns one message into an event per separated part, empty
+// parts are skipped.
+type SplitProcessor struct {
+	separator string
+}
+
+func NewSplitProcessor(conf *SplitConfig) *SplitProcessor {
+	separator := conf.Separator
+	if separator == "" {
+		separator = "\n"
+	}
+	return &SplitProcessor{separator: separator}
+}
+
+func (p *SplitProcessor) Process(e *event.Event) []*event.Event {
+	if !strings.Contains(e.Message, p.separator) {
+		return []*event.Event{e}
+	}
+	parts := strings.Split(e.Message, p.separator)
+	events := make([]*event.Event, 0, len(parts))
+	for _, part := range parts {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		events = append(events, e.Split(part))
+	}
+	return events
+}
@@ -0,0 +1,130 @@
+package record
+
+import (
+	"sync"
+)
+
+type pendingOffset struct {
+	offset uint64
+	acked  bool
+}
+
+type pendingList struct {
+	offsets  []pendingOffset
+	finished bool
+	done     func()
+}
+
+// AckTracker keeps the offsets handed to the outputs per checkpoint key in read
+// order, a checkpoint only moves forward once every earlier offset of the same
+// key has been acknowledged.
+type AckTracker struct {
+	ck      *RecordPoint
+	mutex   sync.Mutex
+	pending map[string]*pendingList
+}
+
+func NewAckTracker(ck *RecordPoint) *AckTracker {
+	t := &AckTracker{
+		ck:      ck,
+		pending: make(map[string]*pendingList),
+	}
+	return t
+}
+
+func (t *AckTracker) Track(key string, offset uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list, ok := t.pending[key]
+	if !ok {
+		list = &pendingList{}
+		t.pending[key] = list
+	}
+	list.finished = false
+	list.done = nil
+	list.offsets = append(list.offsets, pendingOffset{offset: offset})
+}
+
+// Untrack forgets an offset that was tracked but never reached the queue.
+func (t *AckTracker) Untrack(key string, offset uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list, ok := t.pending[key]
+	if !ok {
+		return
+	}
+	for i := len(list.offsets) - 1; i >= 0; i-- {
+		if list.offsets[i].offset == offset && !list.offsets[i].acked {
+			list.offsets = append(list.offsets[:i], list.offsets[i+1:]...)
+			break
+		}
+	}
+	t.commit(key, list, 0, false)
+}
+
+func (t *AckTracker) Ack(key string, offset uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list, ok := t.pending[key]
+	if !ok {
+		return
+	}
+	for i := range list.offsets {
+		if list.offsets[i].offset == offset && !list.offsets[i].acked {
+			list.offsets[i].acked = true
+			break
+		}
+	}
+	var committed uint64
+	var n int
+	for n < len(list.offsets) && list.offsets[n].acked {
+		committed = list.offsets[n].offset
+		n++
+	}
+	list.offsets = list.offsets[n:]
+	t.commit(key, list, committed, n > 0)
+}
+
+// Forget drops the pending offsets of key, the checkpoint keeps the last
+// offset committed.
+func (t *AckTracker) Forget(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.pending, key)
+}
+
+// Finish deletes the checkpoint of key as soon as all its pending offsets are
+// acknowledged, and then calls done if it is not nil.
+func (t *AckTracker) Finish(key string, done func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list, ok := t.pending[key]
+	if !ok {
+		t.ck.DelCheckpoint(key)
+		if done != nil {
+			done()
+		}
+		return
+	}
+	list.finished = true
+	list.done = done
+	t.commit(key, list, 0, false)
+}
+
+func (t *AckTracker) commit(key string, list *pendingList, offset uint64, advanced bool) {
+	if len(list.offsets) > 0 {
+		if advanced {
+			t.ck.SetCheckpoint(key, offset)
+		}
+		return
+	}
+	delete(t.pending, key)
+	if list.finished {
+		t.ck.DelCheckpoint(key)
+		if list.done != nil {
+			list.done()
+		}
+	} else if advanced {
+		t.ck.SetCheckpoint(key, offset)
+	}
+}
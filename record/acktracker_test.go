@@ -0,0 +1,148 @@
+package record
+
+import (
+	"github.com/lucky-abc/cleat/event"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCheckpoint(t *testing.T) *RecordPoint {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cleat-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ck, err := NewCheckpoint(filepath.Join(dir, "checkpoint"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ck.Close()
+		os.RemoveAll(dir)
+	})
+	return ck
+}
+
+func trackedEvent(ck *RecordPoint, key string, offset uint64) *event.Event {
+	e := event.NewEvent("test", "line")
+	e.Checkpoint = event.Checkpoint{Key: key, Offset: offset}
+	ck.Track(e)
+	return e
+}
+
+func expectCheckpoint(t *testing.T, ck *RecordPoint, key string, want uint64) {
+	t.Helper()
+	got, err := ck.GetCheckpoint(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("checkpoint of %s = %d, want %d", key, got, want)
+	}
+}
+
+func TestAckTrackerOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		acks  []int
+		wants []uint64
+	}{
+		{"in order", []int{0, 1, 2}, []uint64{10, 20, 30}},
+		{"last first", []int{2, 1, 0}, []uint64{0, 0, 30}},
+		{"gap", []int{0, 2, 1}, []uint64{10, 10, 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ck := newTestCheckpoint(t)
+			events := []*event.Event{trackedEvent(ck, "k", 10), trackedEvent(ck, "k", 20), trackedEvent(ck, "k", 30)}
+			other := trackedEvent(ck, "other", 5)
+			for i, n := range tt.acks {
+				events[n].Ack()
+				expectCheckpoint(t, ck, "k", tt.wants[i])
+			}
+			expectCheckpoint(t, ck, "other", 0)
+			other.Ack()
+			expectCheckpoint(t, ck, "other", 5)
+		})
+	}
+}
+
+func TestAckTrackerSplit(t *testing.T) {
+	ck := newTestCheckpoint(t)
+	first := trackedEvent(ck, "k", 10)
+	second := trackedEvent(ck, "k", 20)
+	parts := []*event.Event{first.Split("a"), first.Split("b")}
+	//processor处理完原事件后只发送拆分出的事件
+	first.Ack()
+	second.Ack()
+	expectCheckpoint(t, ck, "k", 0)
+	parts[1].Ack()
+	expectCheckpoint(t, ck, "k", 0)
+	parts[0].Ack()
+	expectCheckpoint(t, ck, "k", 20)
+}
+
+// TestAckTrackerFanOut follows two outputs, each acknowledges every event
+// once. The second line is split by a processor, which releases the original.
+func TestAckTrackerFanOut(t *testing.T) {
+	ck := newTestCheckpoint(t)
+	first := trackedEvent(ck, "k", 10)
+	second := trackedEvent(ck, "k", 20)
+	parts := []*event.Event{second.Split("a"), second.Split("b")}
+	second.Ack()
+	first.Retain(1)
+	for _, part := range parts {
+		part.Retain(1)
+	}
+	for _, part := range parts {
+		part.Ack()
+	}
+	parts[0].Ack()
+	first.Ack()
+	expectCheckpoint(t, ck, "k", 0)
+	first.Ack()
+	expectCheckpoint(t, ck, "k", 10)
+	parts[1].Ack()
+	expectCheckpoint(t, ck, "k", 20)
+}
+
+func TestAckTrackerFinish(t *testing.T) {
+	ck := newTestCheckpoint(t)
+	ck.SetCheckpoint("k", 5)
+	e := trackedEvent(ck, "k", 10)
+	dropped := trackedEvent(ck, "k", 20)
+	ck.Untrack(dropped)
+	ck.CompleteCheckpoint("k", "done", "yes")
+	expectCheckpoint(t, ck, "k", 5)
+	if v, _ := ck.GetValue("done"); v != "" {
+		t.Fatal("completed before the ack")
+	}
+	e.Ack()
+	if v, _ := ck.GetValue("k"); v != "" {
+		t.Errorf("checkpoint kept after finish: %s", v)
+	}
+	if v, _ := ck.GetValue("done"); v != "yes" {
+		t.Errorf("done value %q", v)
+	}
+
+	ck.FinishCheckpoint("idle")
+	ck.SetCheckpoint("idle", 1)
+	ck.FinishCheckpoint("idle")
+	expectCheckpoint(t, ck, "idle", 0)
+}
+
+func TestAckTrackerForget(t *testing.T) {
+	ck := newTestCheckpoint(t)
+	trackedEvent(ck, "k", 10).Ack()
+	trackedEvent(ck, "k", 20)
+	trackedEvent(ck, "k", 30)
+	//停止的tunnel丢失了20和30，重新读取后再次发送
+	ck.Forget("k")
+	for _, offset := range []uint64{20, 30, 40} {
+		trackedEvent(ck, "k", offset).Ack()
+	}
+	expectCheckpoint(t, ck, "k", 40)
+}
@@ -1,6 +1,7 @@
 package record
 
 import (
+	"github.com/lucky-abc/cleat/event"
 	"github.com/syndtr/goleveldb/leveldb"
 	"os"
 	"strconv"
@@ -10,6 +11,7 @@ type RecordPoint struct {
 	key    string
 	offset uint64
 	db     *leveldb.DB
+	acks   *AckTracker
 }
 
 func NewCheckpoint(dbpath string) (*RecordPoint, error) {
@@ -30,9 +32,44 @@ func NewCheckpoint(dbpath string) (*RecordPoint, error) {
 	}
 	ck := &RecordPoint{}
 	ck.db = db
+	ck.acks = NewAckTracker(ck)
 	return ck, nil
 }
 
+// Track registers an event that is about to be queued, its checkpoint is only
+// stored after the output acknowledges it.
+func (ck *RecordPoint) Track(e *event.Event) {
+	ck.acks.Track(e.Checkpoint.Key, e.Checkpoint.Offset)
+	e.SetAcker(ck)
+}
+
+func (ck *RecordPoint) Untrack(e *event.Event) {
+	ck.acks.Untrack(e.Checkpoint.Key, e.Checkpoint.Offset)
+}
+
+func (ck *RecordPoint) Ack(cp event.Checkpoint) {
+	ck.acks.Ack(cp.Key, cp.Offset)
+}
+
+// Forget drops the offsets of key still waiting for an ack, the events they
+// belong to were lost with a stopped tunnel and are read again from the checkpoint.
+func (ck *RecordPoint) Forget(key string) {
+	ck.acks.Forget(key)
+}
+
+// FinishCheckpoint removes the checkpoint of key once everything read under it is acknowledged.
+func (ck *RecordPoint) FinishCheckpoint(key string) {
+	ck.acks.Finish(key, nil)
+}
+
+// CompleteCheckpoint finishes key like FinishCheckpoint and then stores value
+// under doneKey, it marks a file that is never appended to as read.
+func (ck *RecordPoint) CompleteCheckpoint(key string, doneKey string, value string) {
+	ck.acks.Finish(key, func() {
+		ck.SetValue(doneKey, value)
+	})
+}
+
 func (ck *RecordPoint) SetCheckpoint(key string, offset uint64) {
 	ck.db.Put([]byte(key), []byte(strconv.FormatUint(offset, 10)), nil)
 }
@@ -56,6 +93,39 @@ func (ck *RecordPoint) GetCheckpoint(key string) (uint64, error) {
 	return v, nil
 }
 
+// SetValue stores string metadata next to the offsets, e.g. the identity of the file behind a path.
+func (ck *RecordPoint) SetValue(key string, value string) {
+	ck.db.Put([]byte(key), []byte(value), nil)
+}
+
+func (ck *RecordPoint) GetValue(key string) (string, error) {
+	val, err := ck.db.Get([]byte(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(val), nil
+}
+
+// Entry is one stored key, offsets and metadata values are both kept as text.
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Entries returns everything stored, sorted by key.
+func (ck *RecordPoint) Entries() ([]Entry, error) {
+	iter := ck.db.NewIterator(nil, nil)
+	defer iter.Release()
+	entries := make([]Entry, 0)
+	for iter.Next() {
+		entries = append(entries, Entry{Key: string(iter.Key()), Value: string(iter.Value())})
+	}
+	return entries, iter.Error()
+}
+
 func (ck *RecordPoint) Close() {
 	if ck.db != nil {
 		ck.db.Close()
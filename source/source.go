@@ -1,7 +1,84 @@
 package source
 
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"sort"
+	"sync"
+)
+
 type Source interface {
 	Start()
 	Process()
 	Stop()
 }
+
+// Reloader is implemented by the sources able to apply a new config section,
+// as returned by their Parser, while running. Reload returns false when the
+// change needs a restart.
+type Reloader interface {
+	Reload(conf interface{}) (bool, error)
+}
+
+// Parser decodes and checks the raw config section of a source type, the
+// errors are reported as a *config.Errors with key paths relative to the section.
+type Parser func(conf interface{}) (interface{}, error)
+
+// Builder creates a source of one type from the config returned by its
+// Parser, tunnelName is used to keep metric names of different tunnels apart.
+type Builder func(tunnelName string, conf interface{}, queue chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) (Source, error)
+
+type registration struct {
+	parser  Parser
+	builder Builder
+}
+
+var (
+	buildersMutex sync.RWMutex
+	builders      = make(map[string]registration)
+)
+
+func RegisterSource(sourceType string, parser Parser, builder Builder) {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+	builders[sourceType] = registration{parser: parser, builder: builder}
+}
+
+func lookup(sourceType string) (registration, error) {
+	buildersMutex.RLock()
+	r, ok := builders[sourceType]
+	buildersMutex.RUnlock()
+	if !ok {
+		return r, fmt.Errorf("unknown source type: %s", sourceType)
+	}
+	return r, nil
+}
+
+func ParseSource(sourceType string, conf interface{}) (interface{}, error) {
+	r, err := lookup(sourceType)
+	if err != nil {
+		return nil, err
+	}
+	return r.parser(conf)
+}
+
+func BuildSource(sourceType string, tunnelName string, conf interface{}, queue chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) (Source, error) {
+	r, err := lookup(sourceType)
+	if err != nil {
+		return nil, err
+	}
+	return r.builder(tunnelName, conf, queue, ck, metricRegistry)
+}
+
+func SourceTypes() []string {
+	buildersMutex.RLock()
+	defer buildersMutex.RUnlock()
+	types := make([]string, 0, len(builders))
+	for t := range builders {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,217 @@
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/logger"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"os"
+	"sync"
+)
+
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "dropOldest"
+
+	checkpointKey = "spool"
+)
+
+// Spool persists the events of a tunnel in leveldb between the sources and
+// the outputs. Entries are keyed by a sequence number and deleted once every
+// output acknowledged them, whatever is left is replayed after a restart.
+type Spool struct {
+	db          *leveldb.DB
+	maxSize     int64
+	overflow    string
+	mutex       sync.Mutex
+	cond        *sync.Cond
+	readSeq     uint64
+	writeSeq    uint64
+	size        int64
+	inflight    map[uint64]int64
+	closed      bool
+	dropCounter *metrics.Counter
+}
+
+func NewSpool(path string, maxSize int64, overflow string, name string, metricRegistry *metrics.MetricRegistry) (*Spool, error) {
+	if overflow != OverflowBlock && overflow != OverflowDropOldest {
+		return nil, errors.Errorf("unknown spool overflow: %s", overflow)
+	}
+	if err := os.MkdirAll(path, 0777); err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		db:       db,
+		maxSize:  maxSize,
+		overflow: overflow,
+		inflight: make(map[uint64]int64),
+	}
+	s.cond = sync.NewCond(&s.mutex)
+	iter := db.NewIterator(nil, nil)
+	first := true
+	for iter.Next() {
+		seq := binary.BigEndian.Uint64(iter.Key())
+		if first {
+			s.readSeq = seq
+			first = false
+		}
+		s.writeSeq = seq + 1
+		s.size += int64(len(iter.Value()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if s.writeSeq > s.readSeq {
+		logger.Loggers().Infof("spool %s replays %d events", path, s.writeSeq-s.readSeq)
+	}
+	s.dropCounter = metrics.NewCounter(name + "-spool-drop-total")
+	metricRegistry.RegisterMetric(s.dropCounter)
+	metricRegistry.RegisterMetric(metrics.NewGauge(name+"-spool-size", func() int64 {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.size
+	}))
+	metricRegistry.RegisterMetric(metrics.NewGauge(name+"-spool-pending", func() int64 {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return int64(s.writeSeq - s.readSeq)
+	}))
+	return s, nil
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Put stores e, when the spool is full it blocks or drops the oldest unread
+// events depending on the overflow policy.
+func (s *Spool) Put(e *event.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.maxSize > 0 && s.size+int64(len(data)) > s.maxSize && !s.closed {
+		if s.overflow == OverflowBlock {
+			s.cond.Wait()
+			continue
+		}
+		//只剩下已发送未确认的事件时不再丢弃
+		if s.readSeq == s.writeSeq {
+			break
+		}
+		if err := s.dropOldest(); err != nil {
+			return err
+		}
+	}
+	if s.closed {
+		return errors.New("spool closed")
+	}
+	if err := s.db.Put(seqKey(s.writeSeq), data, nil); err != nil {
+		return err
+	}
+	s.writeSeq++
+	s.size += int64(len(data))
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *Spool) dropOldest() error {
+	key := seqKey(s.readSeq)
+	data, err := s.db.Get(key, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	if err := s.db.Delete(key, nil); err != nil {
+		return err
+	}
+	s.readSeq++
+	s.size -= int64(len(data))
+	s.dropCounter.Incr(1)
+	return nil
+}
+
+// Next blocks until an event is available, it returns false once the spool is closed.
+func (s *Spool) Next() (*event.Event, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		for s.readSeq == s.writeSeq && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			return nil, false
+		}
+		seq := s.readSeq
+		s.readSeq++
+		data, err := s.db.Get(seqKey(seq), nil)
+		if err != nil {
+			logger.Loggers().Errorf("read spool error: %d,%v", seq, err)
+			continue
+		}
+		e := event.NewEvent("", "")
+		if err := json.Unmarshal(data, e); err != nil {
+			logger.Loggers().Errorf("decode spool event error: %d,%v", seq, err)
+			s.deleteLocked(seq, int64(len(data)))
+			continue
+		}
+		s.inflight[seq] = int64(len(data))
+		e.Checkpoint = event.Checkpoint{Key: checkpointKey, Offset: seq}
+		e.SetAcker(s)
+		return e, true
+	}
+}
+
+// Ack deletes an event every output is done with.
+func (s *Spool) Ack(cp event.Checkpoint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	size, ok := s.inflight[cp.Offset]
+	if !ok {
+		return
+	}
+	delete(s.inflight, cp.Offset)
+	s.deleteLocked(cp.Offset, size)
+}
+
+func (s *Spool) deleteLocked(seq uint64, size int64) {
+	if s.db == nil {
+		return
+	}
+	if err := s.db.Delete(seqKey(seq), nil); err != nil {
+		logger.Loggers().Errorf("delete spool event error: %d,%v", seq, err)
+		return
+	}
+	s.size -= size
+	s.cond.Broadcast()
+}
+
+// CloseReader wakes up Next and Put, the events left in the spool are kept.
+func (s *Spool) CloseReader() {
+	s.mutex.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+}
+
+func (s *Spool) Close() {
+	s.CloseReader()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.db != nil {
+		s.db.Close()
+		s.db = nil
+	}
+}
@@ -0,0 +1,108 @@
+package spool
+
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestSpool(t *testing.T, path string, maxSize int64, overflow string) *Spool {
+	t.Helper()
+	s, err := NewSpool(path, maxSize, overflow, "test", metrics.NewMetricRegstry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func spoolPath(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cleat-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "spool")
+}
+
+func nextMessages(t *testing.T, s *Spool, n int) []*event.Event {
+	t.Helper()
+	events := make([]*event.Event, 0, n)
+	for i := 0; i < n; i++ {
+		e, ok := s.Next()
+		if !ok {
+			t.Fatalf("spool closed after %d events", i)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestSpoolReplayAfterReopen(t *testing.T) {
+	path := spoolPath(t)
+	s := newTestSpool(t, path, 0, OverflowBlock)
+	for i := 0; i < 5; i++ {
+		e := event.NewEvent("/var/log/app.log", fmt.Sprintf("line%d", i))
+		e.SetField("n", fmt.Sprint(i))
+		if err := s.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := nextMessages(t, s, 3)
+	read[1].Ack()
+	s.Close()
+
+	s = newTestSpool(t, path, 0, OverflowBlock)
+	defer s.Close()
+	replayed := nextMessages(t, s, 4)
+	messages := make([]string, 0, len(replayed))
+	for _, e := range replayed {
+		messages = append(messages, e.Message)
+	}
+	want := []string{"line0", "line2", "line3", "line4"}
+	if !reflect.DeepEqual(messages, want) {
+		t.Fatalf("replayed %v, want %v", messages, want)
+	}
+	first := replayed[0]
+	if first.Source != "/var/log/app.log" || first.Fields["n"] != "0" || first.Timestamp.IsZero() {
+		t.Errorf("event not restored: %+v", first)
+	}
+	for _, e := range replayed {
+		e.Ack()
+	}
+	s.Close()
+
+	s = newTestSpool(t, path, 0, OverflowBlock)
+	if s.writeSeq != s.readSeq || s.size != 0 {
+		t.Errorf("acknowledged events kept: %d-%d, %d bytes", s.readSeq, s.writeSeq, s.size)
+	}
+	if err := s.Put(event.NewEvent("test", "after")); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextMessages(t, s, 1)[0]; e.Message != "after" {
+		t.Errorf("read %q after replay", e.Message)
+	}
+	s.Close()
+}
+
+func TestSpoolDropOldest(t *testing.T) {
+	path := spoolPath(t)
+	s := newTestSpool(t, path, 1, OverflowDropOldest)
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		if err := s.Put(event.NewEvent("test", fmt.Sprintf("line%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e := nextMessages(t, s, 1)[0]; e.Message != "line2" {
+		t.Errorf("read %q, want the newest event", e.Message)
+	}
+	if n := s.dropCounter.Value(); n != 2 {
+		t.Errorf("%d events dropped, want 2", n)
+	}
+}
@@ -0,0 +1,455 @@
+package tunnel
+
+import (
+	"fmt"
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/output"
+	"github.com/lucky-abc/cleat/processor"
+	"github.com/lucky-abc/cleat/source"
+	"github.com/lucky-abc/cleat/spool"
+	"github.com/spf13/cast"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OverflowBlock = "block"
+	OverflowDrop  = "drop"
+	OverflowSpool = "spool"
+
+	defaultSpoolSize = 1 << 30
+)
+
+// TunnelConfig is a checked tunnel section, Sources holds the config of each
+// source type as returned by its parser.
+type TunnelConfig struct {
+	Name       string
+	Sources    map[string]interface{}
+	Outputs    []*OutputConfig
+	Processors []*ProcessorConfig
+	Spool      *SpoolConfig
+}
+
+// ProcessorConfig is one step of the processors list, run in the order declared.
+type ProcessorConfig struct {
+	Name string
+	Type string
+	Conf interface{}
+}
+
+// SpoolConfig enables the disk queue between the sources and the outputs,
+// an empty Path means data/spool/<tunnel name>. Every tunnel needs a path of
+// its own, the legacy top level spool section puts each tunnel in a
+// directory named after it under Path.
+type SpoolConfig struct {
+	Path     string
+	MaxSize  int64
+	Overflow string
+}
+
+// OutputConfig is one destination of a tunnel, Overflow decides what happens
+// when its queue is full: block the whole tunnel, drop for this output only,
+// or spool the backlog of this output on disk. A tunnel with several outputs
+// spools by default so an unreachable one does not stall the others, a
+// single output blocks.
+type OutputConfig struct {
+	Name      string
+	Type      string
+	QueueSize int
+	Overflow  string
+	Conf      interface{}
+}
+
+type tunnelSection struct {
+	Name       string                 `mapstructure:"name"`
+	Sources    map[string]interface{} `mapstructure:"sources"`
+	Outputs    []interface{}          `mapstructure:"outputs"`
+	Output     interface{}            `mapstructure:"output"`
+	Processors interface{}            `mapstructure:"processors"`
+	Spool      interface{}            `mapstructure:"spool"`
+}
+
+type spoolSection struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Path     string `mapstructure:"path"`
+	MaxSize  string `mapstructure:"maxSize"`
+	Overflow string `mapstructure:"overflow"`
+}
+
+// outputKeys and processorKeys are handled here, the rest of an output or
+// processor section goes to the parser of its type.
+var (
+	outputKeys    = []string{"name", "queueSize", "overflow"}
+	processorKeys = []string{"name"}
+)
+
+func (c *TunnelConfig) sourceTypes() []string {
+	types := make([]string, 0, len(c.Sources))
+	for t := range c.Sources {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ParseConfig checks the tunnels section of sc, configs without it get the
+// historical filelog and windowevent tunnels sharing the top level output.
+// All the problems found are returned in a *config.Errors.
+func ParseConfig(sc *config.SystemConfig) ([]*TunnelConfig, error) {
+	if sc.Tunnels == nil {
+		return legacyConfig(sc)
+	}
+	errs := &config.Errors{}
+	var sections []tunnelSection
+	if err := config.Decode(sc.Tunnels, &sections); err != nil {
+		errs.Merge("tunnels", err)
+		return nil, errs
+	}
+	if len(sections) == 0 {
+		errs.Add("tunnels", "must not be empty")
+	}
+	tunnels := make([]*TunnelConfig, 0, len(sections))
+	names := make(map[string]bool)
+	for i, section := range sections {
+		path := fmt.Sprintf("tunnels[%d]", i)
+		switch {
+		case section.Name == "":
+			errs.Add(path+".name", "must not be empty")
+		case names[section.Name]:
+			errs.Add(path+".name", "%s is duplicated", section.Name)
+		}
+		names[section.Name] = true
+		if len(section.Outputs) == 0 && section.Output == nil {
+			errs.Add(path+".outputs", "must not be empty")
+		}
+		tc := &TunnelConfig{
+			Name:    section.Name,
+			Sources: parseSources(section.Sources, errs, path+".sources"),
+			Outputs: parseOutputs(section.Name, section.Outputs, section.Output, errs, path),
+		}
+		tc.Processors = parseProcessors(section.Processors, errs, path+".processors")
+		tc.Spool = parseSpool(section.Spool, errs, path+".spool")
+		tunnels = append(tunnels, tc)
+	}
+	checkSpoolPaths(tunnels, errs)
+	if errs.Len() > 0 {
+		return nil, errs
+	}
+	return tunnels, nil
+}
+
+func parseSources(sections map[string]interface{}, errs *config.Errors, path string) map[string]interface{} {
+	if len(sections) == 0 {
+		errs.Add(path, "must not be empty")
+	}
+	sources := make(map[string]interface{}, len(sections))
+	for sourceType, section := range sections {
+		conf, err := source.ParseSource(strings.ToLower(sourceType), section)
+		errs.Merge(config.JoinPath(path, sourceType), err)
+		sources[strings.ToLower(sourceType)] = conf
+	}
+	return sources
+}
+
+// splitKeys separates the keys handled by the tunnel from the section of a
+// plugin, the keys are matched case-insensitively.
+func splitKeys(section map[string]interface{}, keys []string) (common map[string]interface{}, rest map[string]interface{}) {
+	common = make(map[string]interface{})
+	rest = make(map[string]interface{}, len(section))
+	for k, v := range section {
+		rest[k] = v
+		for _, key := range keys {
+			if strings.EqualFold(k, key) {
+				common[key] = v
+				delete(rest, k)
+			}
+		}
+	}
+	return common, rest
+}
+
+// singleEntry reads a list item declaring exactly one plugin, like
+// "- grok: {...}", its section may be empty.
+func singleEntry(item interface{}) (string, map[string]interface{}, bool) {
+	itemMap, err := cast.ToStringMapE(item)
+	if err != nil || len(itemMap) != 1 {
+		return "", nil, false
+	}
+	for pluginType, v := range itemMap {
+		if v == nil {
+			return pluginType, map[string]interface{}{}, true
+		}
+		section, err := cast.ToStringMapE(v)
+		return pluginType, section, err == nil
+	}
+	return "", nil, false
+}
+
+func parseProcessors(v interface{}, errs *config.Errors, path string) []*ProcessorConfig {
+	if v == nil {
+		return nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		errs.Add(path, "must be a list")
+		return nil
+	}
+	processors := make([]*ProcessorConfig, 0, len(list))
+	names := make(map[string]bool)
+	for i, item := range list {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		processorType, section, ok := singleEntry(item)
+		if !ok {
+			errs.Add(itemPath, "must declare exactly one processor type")
+			continue
+		}
+		common, rest := splitKeys(section, processorKeys)
+		pc := &ProcessorConfig{
+			Name: cast.ToString(common["name"]),
+			Type: strings.ToLower(processorType),
+		}
+		if pc.Name == "" {
+			pc.Name = fmt.Sprintf("%s%d", pc.Type, i)
+		}
+		if names[pc.Name] {
+			errs.Add(itemPath+"."+processorType+".name", "%s is duplicated", pc.Name)
+		}
+		names[pc.Name] = true
+		conf, err := processor.ParseProcessor(pc.Type, rest)
+		errs.Merge(itemPath+"."+processorType, err)
+		pc.Conf = conf
+		processors = append(processors, pc)
+	}
+	return processors
+}
+
+func parseSpool(v interface{}, errs *config.Errors, path string) *SpoolConfig {
+	if v == nil {
+		return nil
+	}
+	section := &spoolSection{}
+	if err := config.Decode(v, section); err != nil {
+		errs.Merge(path, err)
+		return nil
+	}
+	if !section.Enabled {
+		return nil
+	}
+	sc := &SpoolConfig{
+		Path:     section.Path,
+		MaxSize:  defaultSpoolSize,
+		Overflow: section.Overflow,
+	}
+	if section.MaxSize != "" {
+		size, err := parseSize(section.MaxSize)
+		if err != nil {
+			errs.Add(path+".maxSize", "%v", err)
+		}
+		sc.MaxSize = size
+	}
+	switch strings.ToLower(sc.Overflow) {
+	case "", OverflowBlock:
+		sc.Overflow = spool.OverflowBlock
+	case strings.ToLower(spool.OverflowDropOldest):
+		sc.Overflow = spool.OverflowDropOldest
+	default:
+		errs.Add(path+".overflow", "must be %s or %s", spool.OverflowBlock, spool.OverflowDropOldest)
+	}
+	return sc
+}
+
+// checkSpoolPaths rejects a spool path shared by several tunnels, they would
+// read and delete the segments of each other.
+func checkSpoolPaths(tunnels []*TunnelConfig, errs *config.Errors) {
+	used := make(map[string]string)
+	for i, tc := range tunnels {
+		if tc.Spool == nil || tc.Spool.Path == "" {
+			continue
+		}
+		path := filepath.Clean(tc.Spool.Path)
+		if name, ok := used[path]; ok {
+			errs.Add(fmt.Sprintf("tunnels[%d].spool.path", i), "%s is already used by tunnel %s", tc.Spool.Path, name)
+			continue
+		}
+		used[path] = tc.Name
+	}
+}
+
+// parseSize accepts a byte count with an optional KB, MB or GB suffix.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for suffix, u := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, suffix))
+			unit = u
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(s, "B"), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * unit, nil
+}
+
+type outputEntry struct {
+	path       string
+	outputType string
+	section    map[string]interface{}
+}
+
+// parseOutputs accepts both the outputs list, where the same type may appear
+// several times, and the single output map with one key per type. tunnelName
+// is the default syslog appName of the outputs.
+func parseOutputs(tunnelName string, outputsList []interface{}, outputMap interface{}, errs *config.Errors, path string) []*OutputConfig {
+	entries := make([]outputEntry, 0)
+	for i, item := range outputsList {
+		itemPath := fmt.Sprintf("%s.outputs[%d]", path, i)
+		outputType, section, ok := singleEntry(item)
+		if !ok {
+			errs.Add(itemPath, "must declare exactly one output type")
+			continue
+		}
+		entries = append(entries, outputEntry{path: itemPath + "." + outputType, outputType: outputType, section: section})
+	}
+	if outputMap != nil {
+		m, err := cast.ToStringMapE(outputMap)
+		if err != nil {
+			errs.Add(config.JoinPath(path, "output"), "must be a map")
+		}
+		types := make([]string, 0, len(m))
+		for t := range m {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			entryPath := config.JoinPath(path, "output."+t)
+			_, section, ok := singleEntry(map[string]interface{}{t: m[t]})
+			if !ok {
+				errs.Add(entryPath, "must be a map")
+				continue
+			}
+			entries = append(entries, outputEntry{path: entryPath, outputType: t, section: section})
+		}
+	}
+	outputs := make([]*OutputConfig, 0, len(entries))
+	names := make(map[string]bool)
+	for i, entry := range entries {
+		common, rest := splitKeys(entry.section, outputKeys)
+		oc := &OutputConfig{
+			Name:     cast.ToString(common["name"]),
+			Type:     strings.ToLower(entry.outputType),
+			Overflow: strings.ToLower(cast.ToString(common["overflow"])),
+		}
+		if v, ok := common["queueSize"]; ok {
+			size, err := cast.ToIntE(v)
+			if err != nil || size <= 0 {
+				errs.Add(entry.path+".queueSize", "must be a positive number")
+			}
+			oc.QueueSize = size
+		}
+		if oc.Name == "" {
+			oc.Name = oc.Type
+			if names[oc.Name] {
+				oc.Name = fmt.Sprintf("%s%d", oc.Type, i)
+			}
+		}
+		if names[oc.Name] {
+			errs.Add(entry.path+".name", "%s is duplicated", oc.Name)
+		}
+		names[oc.Name] = true
+		if oc.QueueSize <= 0 {
+			oc.QueueSize = queueSize
+		}
+		switch oc.Overflow {
+		case "":
+			//丢弃的数据不会再发送，只有明确配置drop才丢弃
+			oc.Overflow = OverflowBlock
+			if len(entries) > 1 {
+				oc.Overflow = OverflowSpool
+			}
+		case OverflowBlock, OverflowDrop, OverflowSpool:
+		default:
+			errs.Add(entry.path+".overflow", "must be %s, %s or %s", OverflowBlock, OverflowDrop, OverflowSpool)
+		}
+		if config.MapValue(rest, "appName") == nil {
+			rest["appName"] = tunnelName
+		}
+		conf, err := output.ParseOutput(oc.Type, rest)
+		errs.Merge(entry.path, err)
+		oc.Conf = conf
+		outputs = append(outputs, oc)
+	}
+	return outputs
+}
+
+type legacyTunnel struct {
+	name       string
+	sourceType string
+	path       string
+	section    interface{}
+}
+
+// legacyConfig reads the top level files, windows.event, output, processors
+// and spool sections.
+func legacyConfig(sc *config.SystemConfig) ([]*TunnelConfig, error) {
+	errs := &config.Errors{}
+	processors := parseProcessors(sc.Processors, errs, "processors")
+	spoolConfig := parseSpool(sc.Spool, errs, "spool")
+	legacy := make([]legacyTunnel, 0, 2)
+	if sc.Windows != nil {
+		windows, err := cast.ToStringMapE(sc.Windows)
+		if err != nil {
+			errs.Add("windows", "must be a map")
+		}
+		for k, v := range windows {
+			if !strings.EqualFold(k, "event") {
+				errs.Add("windows."+k, "unknown key")
+				continue
+			}
+			legacy = append(legacy, legacyTunnel{name: "windowevent", sourceType: "windows", path: "windows.event", section: v})
+		}
+	}
+	if sc.Files != nil {
+		legacy = append(legacy, legacyTunnel{name: "filelog", sourceType: "files", path: "files", section: sc.Files})
+	}
+	if len(legacy) == 0 {
+		errs.Add("tunnels", "no tunnel, files or windows.event section in config file")
+	}
+	if sc.Output == nil {
+		errs.Add("output", "must not be empty")
+	}
+	tunnels := make([]*TunnelConfig, 0, len(legacy))
+	for i, l := range legacy {
+		conf, err := source.ParseSource(l.sourceType, l.section)
+		errs.Merge(l.path, err)
+		//各个tunnel的输出配置相同，只有appName不同，错误只记录一次
+		outputErrs := &config.Errors{}
+		outputs := parseOutputs(l.name, nil, sc.Output, outputErrs, "")
+		if i == 0 {
+			errs.Merge("", outputErrs.Err())
+		}
+		var tunnelSpool *SpoolConfig
+		if spoolConfig != nil {
+			c := *spoolConfig
+			if c.Path != "" {
+				c.Path = filepath.Join(c.Path, l.name)
+			}
+			tunnelSpool = &c
+		}
+		tunnels = append(tunnels, &TunnelConfig{
+			Name:       l.name,
+			Sources:    map[string]interface{}{l.sourceType: conf},
+			Outputs:    outputs,
+			Processors: processors,
+			Spool:      tunnelSpool,
+		})
+	}
+	if errs.Len() > 0 {
+		return nil, errs
+	}
+	return tunnels, nil
+}
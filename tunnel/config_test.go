@@ -0,0 +1,144 @@
+package tunnel
+
+import (
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/source"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputOverflow(t *testing.T) {
+	tcp := map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": 514}
+	udp := map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": 514}
+	tcpDrop := map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": 514, "overflow": "drop"}
+	tests := []struct {
+		name    string
+		section map[string]interface{}
+		want    map[string]string
+	}{
+		{
+			name:    "single output",
+			section: map[string]interface{}{"outputs": []interface{}{map[string]interface{}{"tcp": tcp}}},
+			want:    map[string]string{"tcp": OverflowBlock},
+		},
+		{
+			name: "outputs list",
+			section: map[string]interface{}{"outputs": []interface{}{
+				map[string]interface{}{"tcp": tcp},
+				map[string]interface{}{"udp": udp},
+			}},
+			want: map[string]string{"tcp": OverflowSpool, "udp": OverflowSpool},
+		},
+		{
+			name:    "output map",
+			section: map[string]interface{}{"output": map[string]interface{}{"tcp": tcp, "udp": udp}},
+			want:    map[string]string{"tcp": OverflowSpool, "udp": OverflowSpool},
+		},
+		{
+			name: "explicit drop",
+			section: map[string]interface{}{"outputs": []interface{}{
+				map[string]interface{}{"tcp": tcpDrop},
+				map[string]interface{}{"udp": udp},
+			}},
+			want: map[string]string{"tcp": OverflowDrop, "udp": OverflowSpool},
+		},
+		{
+			name: "explicit block",
+			section: map[string]interface{}{"outputs": []interface{}{
+				map[string]interface{}{"tcp": tcp},
+				map[string]interface{}{"udp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": 514, "overflow": "block"}},
+			}},
+			want: map[string]string{"tcp": OverflowSpool, "udp": OverflowBlock},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := map[string]interface{}{
+				"name":    "t",
+				"sources": map[string]interface{}{"stub": nil},
+			}
+			for k, v := range tt.section {
+				section[k] = v
+			}
+			configs := parseTestConfig(t, []interface{}{section})
+			got := make(map[string]string)
+			for _, oc := range configs[0].Outputs {
+				got[oc.Name] = oc.Overflow
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("outputs %v, want %v", got, tt.want)
+			}
+			for name, overflow := range tt.want {
+				if got[name] != overflow {
+					t.Errorf("output %s overflow %q, want %q", name, got[name], overflow)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputOverflowInvalid(t *testing.T) {
+	_, err := ParseConfig(&config.SystemConfig{Tunnels: []interface{}{map[string]interface{}{
+		"name":    "t",
+		"sources": map[string]interface{}{"stub": nil},
+		"outputs": []interface{}{map[string]interface{}{"udp": map[string]interface{}{
+			"serverIP": "127.0.0.1", "serverPort": 514, "overflow": "spill",
+		}}},
+	}}})
+	if err == nil {
+		t.Fatal("unknown overflow accepted")
+	}
+	want := "tunnels[0].outputs[0].udp.overflow"
+	if got := err.Error(); !strings.Contains(got, want) {
+		t.Fatalf("error %q does not name %s", got, want)
+	}
+}
+
+func TestSpoolPaths(t *testing.T) {
+	for _, sourceType := range []string{"files", "windows"} {
+		source.RegisterSource(sourceType, func(conf interface{}) (interface{}, error) {
+			return conf, nil
+		}, nil)
+	}
+	output := map[string]interface{}{"udp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": 514}}
+	spoolSection := map[string]interface{}{"enabled": true, "path": "/var/lib/cleat/spool"}
+	configs, err := ParseConfig(&config.SystemConfig{
+		Files:   map[string]interface{}{},
+		Windows: map[string]interface{}{"event": map[string]interface{}{}},
+		Output:  output,
+		Spool:   spoolSection,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]string)
+	for _, tc := range configs {
+		paths[tc.Name] = tc.Spool.Path
+	}
+	want := map[string]string{
+		"filelog":     filepath.Join("/var/lib/cleat/spool", "filelog"),
+		"windowevent": filepath.Join("/var/lib/cleat/spool", "windowevent"),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("legacy spool paths %v, want %v", paths, want)
+	}
+
+	tunnel := func(name string, spool map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"name":    name,
+			"sources": map[string]interface{}{"stub": nil},
+			"outputs": []interface{}{output},
+			"spool":   spool,
+		}
+	}
+	_, err = ParseConfig(&config.SystemConfig{Tunnels: []interface{}{
+		tunnel("a", spoolSection),
+		tunnel("b", map[string]interface{}{"enabled": true, "path": "/var/lib/cleat/spool/"}),
+		tunnel("c", map[string]interface{}{"enabled": true}),
+	}})
+	if err == nil || !strings.Contains(err.Error(), "tunnels[1].spool.path") {
+		t.Fatalf("shared spool path accepted: %v", err)
+	}
+}
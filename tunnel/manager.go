@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"github.com/lucky-abc/cleat/logger"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Manager keeps the running tunnels in line with the config, Apply only
+// touches the tunnels whose config changed.
+type Manager struct {
+	dataPath       string
+	ck             *record.RecordPoint
+	metricRegistry *metrics.MetricRegistry
+	tunnels        map[string]*TunnelModel
+}
+
+func NewManager(dataPath string, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) *Manager {
+	return &Manager{
+		dataPath:       dataPath,
+		ck:             ck,
+		metricRegistry: metricRegistry,
+		tunnels:        make(map[string]*TunnelModel),
+	}
+}
+
+func (m *Manager) Apply(configs []*TunnelConfig) {
+	names := make(map[string]bool, len(configs))
+	for _, conf := range configs {
+		names[conf.Name] = true
+	}
+	for name, t := range m.tunnels {
+		if !names[name] {
+			logger.Loggers().Infof("tunnel %s removed from config", name)
+			t.Stop()
+			delete(m.tunnels, name)
+			m.unregisterMetrics(name, names)
+		}
+	}
+	for _, conf := range configs {
+		old, ok := m.tunnels[conf.Name]
+		if ok && reflect.DeepEqual(old.conf, conf) {
+			continue
+		}
+		if ok && old.reloadSources(conf) {
+			logger.Loggers().Infof("tunnel %s sources reloaded", conf.Name)
+			continue
+		}
+		if ok {
+			logger.Loggers().Infof("tunnel %s config changed, restart it", conf.Name)
+			//先停止旧的tunnel，队列中的数据发送完成后再启动新的
+			old.Stop()
+			delete(m.tunnels, conf.Name)
+			m.unregisterMetrics(conf.Name, names)
+		}
+		t, err := NewTunnel(conf, m.dataPath, m.ck, m.metricRegistry)
+		if err != nil {
+			logger.Loggers().Error("create tunnel error:", err)
+			m.unregisterMetrics(conf.Name, names)
+			if !ok {
+				continue
+			}
+			logger.Loggers().Warnf("tunnel %s keeps the previous config", conf.Name)
+			if t, err = NewTunnel(old.conf, m.dataPath, m.ck, m.metricRegistry); err != nil {
+				logger.Loggers().Error("create tunnel error:", err)
+				continue
+			}
+		}
+		t.Start()
+		t.Transfer()
+		m.tunnels[conf.Name] = t
+	}
+}
+
+// unregisterMetrics drops the metrics of a stopped tunnel, a restarted one
+// registers them again. Those of the tunnels named name-* are kept.
+func (m *Manager) unregisterMetrics(name string, names map[string]bool) {
+	keep := make([]string, 0)
+	for other := range names {
+		if strings.HasPrefix(other, name+"-") {
+			keep = append(keep, other)
+		}
+	}
+	m.metricRegistry.UnregisterMetrics(name, keep...)
+}
+
+func (m *Manager) Stop() {
+	names := make([]string, 0, len(m.tunnels))
+	for name := range m.tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.tunnels[name].Stop()
+	}
+	m.tunnels = make(map[string]*TunnelModel)
+}
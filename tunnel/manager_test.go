@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"github.com/lucky-abc/cleat/source"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var stubBuilds int64
+
+type stubSource struct{}
+
+func (stubSource) Start()   {}
+func (stubSource) Process() {}
+func (stubSource) Stop()    {}
+
+func init() {
+	source.RegisterSource("stub", func(conf interface{}) (interface{}, error) {
+		return conf, nil
+	}, func(tunnelName string, conf interface{}, queue chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) (source.Source, error) {
+		atomic.AddInt64(&stubBuilds, 1)
+		return stubSource{}, nil
+	})
+}
+
+func parseTestConfig(t *testing.T, tunnels []interface{}) []*TunnelConfig {
+	t.Helper()
+	configs, err := ParseConfig(&config.SystemConfig{Tunnels: tunnels})
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	return configs
+}
+
+func TestApplyUnchangedConfigIsNoop(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	outputs := map[string][]interface{}{
+		"tcp":       {map[string]interface{}{"tcp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": port}}},
+		"udp":       {map[string]interface{}{"udp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": port}}},
+		"tcp-octet": {map[string]interface{}{"tcp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": port, "framing": "octet"}}},
+		"fan-out": {
+			map[string]interface{}{"tcp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": port}},
+			map[string]interface{}{"udp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": port}},
+		},
+	}
+	for name, outs := range outputs {
+		t.Run(name, func(t *testing.T) {
+			tunnels := func() []interface{} {
+				return []interface{}{map[string]interface{}{
+					"name":    "t",
+					"sources": map[string]interface{}{"stub": map[string]interface{}{"path": "x"}},
+					"outputs": outs,
+				}}
+			}
+			atomic.StoreInt64(&stubBuilds, 0)
+			m := NewManager(newTestDataPath(t), nil, metrics.NewMetricRegstry())
+			defer m.Stop()
+			m.Apply(parseTestConfig(t, tunnels()))
+			running := m.tunnels["t"]
+			if running == nil {
+				t.Fatal("tunnel not started")
+			}
+			m.Apply(parseTestConfig(t, tunnels()))
+			if m.tunnels["t"] != running {
+				t.Fatal("unchanged config restarted the tunnel")
+			}
+			if n := atomic.LoadInt64(&stubBuilds); n != 1 {
+				t.Fatalf("source built %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestApplyUnregistersMetricsOfStoppedTunnels(t *testing.T) {
+	tunnel := func(name string) interface{} {
+		return map[string]interface{}{
+			"name":    name,
+			"sources": map[string]interface{}{"stub": map[string]interface{}{"path": "x"}},
+			"outputs": []interface{}{map[string]interface{}{"udp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": 9}}},
+		}
+	}
+	mr := metrics.NewMetricRegstry()
+	m := NewManager(newTestDataPath(t), nil, mr)
+	defer m.Stop()
+	m.Apply(parseTestConfig(t, []interface{}{tunnel("a"), tunnel("a-b")}))
+	m.Apply(parseTestConfig(t, []interface{}{tunnel("a-b")}))
+	kept := 0
+	for name := range mr.Metrics() {
+		if !strings.HasPrefix(name, "a-b-") {
+			t.Errorf("metric %s of the removed tunnel kept", name)
+			continue
+		}
+		kept++
+		if _, labels := mr.Describe(name); len(labels) == 0 || labels[0].Value != "a-b" {
+			t.Errorf("metric %s lost its labels: %v", name, labels)
+		}
+	}
+	if kept == 0 {
+		t.Error("metrics of the running tunnel removed")
+	}
+}
+
+func TestRestartReleasesUnsentCheckpoints(t *testing.T) {
+	ck := newTestCheckpoint(t)
+	m := NewManager(newTestDataPath(t), ck, metrics.NewMetricRegstry())
+	defer m.Stop()
+	m.Apply(parseTestConfig(t, []interface{}{emitTunnel(2, tcpOutput(closedPort(t)))}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ioutil.ReadAll(conn)
+	}()
+	//输出不可用时重启，前两个事件没有确认
+	m.Apply(parseTestConfig(t, []interface{}{emitTunnel(4, tcpOutput(ln.Addr().(*net.TCPAddr).Port))}))
+	waitCheckpoint(t, ck, 4)
+}
@@ -1,8 +1,26 @@
 package tunnel
 
 import (
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/logger"
+	"github.com/lucky-abc/cleat/metrics"
 	"github.com/lucky-abc/cleat/output"
+	"github.com/lucky-abc/cleat/processor"
+	"github.com/lucky-abc/cleat/record"
 	"github.com/lucky-abc/cleat/source"
+	"github.com/lucky-abc/cleat/spool"
+	"github.com/pkg/errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	queueSize = 1024
+	// drainTimeout bounds how long Stop waits for the queues to drain, what
+	// is left is not acknowledged and read again after the restart.
+	drainTimeout = 10 * time.Second
 )
 
 type Tunnel interface {
@@ -11,23 +29,354 @@ type Tunnel interface {
 	Stop()
 }
 
+// outputPipe feeds one output from its own queue so a slow destination only
+// backs up its own events. With a spool the backlog of the output is kept on
+// disk, the other outputs go on while it is down.
+type outputPipe struct {
+	name        string
+	output      output.Output
+	queue       chan *event.Event
+	overflow    string
+	spool       *spool.Spool
+	dropCounter *metrics.Counter
+}
+
+func (p *outputPipe) offer(e *event.Event, stopChan chan struct{}) {
+	switch p.overflow {
+	case OverflowSpool:
+		//写入该输出的spool后即确认，由feed继续发送
+		if err := p.spool.Put(e); err != nil {
+			logger.Loggers().Errorf("output %s write spool error: %v", p.name, err)
+			return
+		}
+		e.Ack()
+	case OverflowBlock:
+		select {
+		case p.queue <- e:
+		case <-stopChan:
+		}
+	default:
+		select {
+		case p.queue <- e:
+		default:
+			p.dropCounter.Incr(1)
+			e.Ack()
+		}
+	}
+}
+
+// feed moves the spooled events to the queue of the output until the spool
+// is closed, the events not sent yet are kept for the next start.
+func (p *outputPipe) feed(stopChan chan struct{}) {
+	defer close(p.queue)
+	for {
+		e, ok := p.spool.Next()
+		if !ok {
+			return
+		}
+		select {
+		case p.queue <- e:
+		case <-stopChan:
+			return
+		}
+	}
+}
+
 type TunnelModel struct {
-	Source source.Source
-	Output output.Output
+	Name         string
+	Sources      []source.Source
+	Outputs      []output.Output
+	conf         *TunnelConfig
+	sourceTypes  []string
+	queue        chan *event.Event
+	pipes        []*outputPipe
+	processors   *processor.Pipeline
+	queueWait    *metrics.Timer
+	spool        *spool.Spool
+	spoolDone    sync.WaitGroup
+	dispatchDone sync.WaitGroup
+	feedDone     sync.WaitGroup
+	stopChan     chan struct{}
+	ck           *record.RecordPoint
+	keys         map[string]bool
+}
+
+// NewTunnel builds the sources and the outputs declared by conf, the sources
+// share one queue which is copied into the queue of every output, through
+// the spool when it is enabled. The outputs which spool their backlog keep
+// it in data/outputs/<tunnel name>/<output name>.
+func NewTunnel(conf *TunnelConfig, dataPath string, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) (*TunnelModel, error) {
+	q := make(chan *event.Event, queueSize)
+	t := &TunnelModel{
+		Name:       conf.Name,
+		conf:       conf,
+		Sources:    make([]source.Source, 0, len(conf.Sources)),
+		Outputs:    make([]output.Output, 0, len(conf.Outputs)),
+		queue:      q,
+		pipes:      make([]*outputPipe, 0, len(conf.Outputs)),
+		processors: processor.NewPipeline(),
+		stopChan:   make(chan struct{}),
+		ck:         ck,
+		keys:       make(map[string]bool),
+	}
+	metricRegistry.RegisterLabels(conf.Name, "", "tunnel", conf.Name)
+	metricGauge := metrics.NewGauge(conf.Name+"-channal-size", func() int64 {
+		return int64(len(q))
+	})
+	metricRegistry.RegisterMetric(metricGauge)
+	t.queueWait = metrics.NewTimer(conf.Name + "-queue-wait-time")
+	metricRegistry.RegisterMetric(t.queueWait)
+
+	for _, sourceType := range conf.sourceTypes() {
+		s, err := source.BuildSource(sourceType, conf.Name, conf.Sources[sourceType], q, ck, metricRegistry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tunnel %s create %s source error", conf.Name, sourceType)
+		}
+		t.Sources = append(t.Sources, s)
+		t.sourceTypes = append(t.sourceTypes, sourceType)
+	}
+	if len(t.Sources) == 0 {
+		return nil, errors.Errorf("tunnel %s has no source", conf.Name)
+	}
+	for _, pc := range conf.Processors {
+		name := conf.Name + "-processor[" + pc.Name + "]"
+		metricRegistry.RegisterLabels(name, "processor", "processor", pc.Name)
+		p, err := processor.BuildProcessor(pc.Type, pc.Conf, metricRegistry, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tunnel %s create processor %s error", conf.Name, pc.Name)
+		}
+		t.processors.Add(name, p, metricRegistry)
+	}
+	if len(conf.Outputs) == 0 {
+		return nil, errors.Errorf("tunnel %s has no output", conf.Name)
+	}
+	for _, oc := range conf.Outputs {
+		name := conf.Name
+		if len(conf.Outputs) > 1 {
+			name = conf.Name + "-" + oc.Name
+			metricRegistry.RegisterLabels(name, "", "output", oc.Name)
+		}
+		pq := make(chan *event.Event, oc.QueueSize)
+		o, err := output.BuildOutput(oc.Type, oc.Conf, pq, metricRegistry, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tunnel %s create output %s error", conf.Name, oc.Name)
+		}
+		pipe := &outputPipe{
+			name:        name,
+			output:      o,
+			queue:       pq,
+			overflow:    oc.Overflow,
+			dropCounter: metrics.NewCounter(name + "-output-drop-total"),
+		}
+		if oc.Overflow == OverflowSpool {
+			maxSize := int64(defaultSpoolSize)
+			if conf.Spool != nil {
+				maxSize = conf.Spool.MaxSize
+			}
+			path := filepath.Join(dataPath, "outputs", conf.Name, oc.Name)
+			sp, err := spool.NewSpool(path, maxSize, spool.OverflowBlock, name+"-output", metricRegistry)
+			if err != nil {
+				t.closeSpools()
+				return nil, errors.Wrapf(err, "tunnel %s open output %s spool error", conf.Name, oc.Name)
+			}
+			pipe.spool = sp
+		}
+		metricRegistry.RegisterMetric(pipe.dropCounter)
+		metricRegistry.RegisterMetric(metrics.NewGauge(name+"-output-channal-size", func() int64 {
+			return int64(len(pq))
+		}))
+		t.Outputs = append(t.Outputs, o)
+		t.pipes = append(t.pipes, pipe)
+	}
+	if conf.Spool != nil {
+		path := conf.Spool.Path
+		if path == "" {
+			path = filepath.Join(dataPath, "spool", conf.Name)
+		}
+		sp, err := spool.NewSpool(path, conf.Spool.MaxSize, conf.Spool.Overflow, conf.Name, metricRegistry)
+		if err != nil {
+			t.closeSpools()
+			return nil, errors.Wrapf(err, "tunnel %s open spool error", conf.Name)
+		}
+		t.spool = sp
+	}
+	return t, nil
+}
+
+func (t *TunnelModel) Start() {
+	for _, o := range t.Outputs {
+		o.Start()
+	}
+	for _, s := range t.Sources {
+		s.Start()
+	}
+}
+
+func (t *TunnelModel) Transfer() {
+	for _, o := range t.Outputs {
+		go o.Process()
+	}
+	for _, p := range t.pipes {
+		if p.spool != nil {
+			t.feedDone.Add(1)
+			go func(p *outputPipe) {
+				defer t.feedDone.Done()
+				p.feed(t.stopChan)
+			}(p)
+		}
+	}
+	t.dispatchDone.Add(1)
+	if t.spool != nil {
+		spooled := make(chan *event.Event)
+		t.spoolDone.Add(1)
+		go t.spoolWrite()
+		go t.spoolRead(spooled)
+		go t.dispatch(spooled)
+	} else {
+		go t.dispatch(t.queue)
+	}
+	for _, s := range t.Sources {
+		s.Process()
+	}
+}
+
+// spoolWrite persists the events of the sources, which are acknowledged as
+// soon as they are on disk.
+func (t *TunnelModel) spoolWrite() {
+	defer t.spoolDone.Done()
+	for e := range t.queue {
+		t.keys[e.Checkpoint.Key] = true
+		if err := t.spool.Put(e); err != nil {
+			logger.Loggers().Errorf("tunnel %s write spool error: %v", t.Name, err)
+			continue
+		}
+		e.Ack()
+	}
+}
+
+func (t *TunnelModel) spoolRead(spooled chan *event.Event) {
+	defer close(spooled)
+	for {
+		e, ok := t.spool.Next()
+		if !ok {
+			return
+		}
+		spooled <- e
+	}
+}
+
+func (t *TunnelModel) dispatch(in chan *event.Event) {
+	defer t.dispatchDone.Done()
+	for raw := range in {
+		if t.spool == nil {
+			t.keys[raw.Checkpoint.Key] = true
+		}
+		t.queueWait.UpdateSince(raw.Created())
+		for _, e := range t.processors.Process(raw) {
+			e.Retain(len(t.pipes) - 1)
+			for _, p := range t.pipes {
+				p.offer(e, t.stopChan)
+			}
+		}
+	}
+	for _, p := range t.pipes {
+		if p.spool == nil {
+			close(p.queue)
+		}
+	}
 }
 
-func (t *TunnelModel) startSource() {
-	t.Source.Start()
+// reloadSources hands the new source sections to the running sources when
+// nothing else changed, it returns false if the tunnel must be restarted.
+func (t *TunnelModel) reloadSources(conf *TunnelConfig) bool {
+	old := t.conf
+	if !reflect.DeepEqual(old.Outputs, conf.Outputs) || !reflect.DeepEqual(old.Processors, conf.Processors) ||
+		!reflect.DeepEqual(old.Spool, conf.Spool) || !reflect.DeepEqual(old.sourceTypes(), conf.sourceTypes()) {
+		return false
+	}
+	for i, sourceType := range t.sourceTypes {
+		if reflect.DeepEqual(old.Sources[sourceType], conf.Sources[sourceType]) {
+			continue
+		}
+		if _, ok := t.Sources[i].(source.Reloader); !ok {
+			return false
+		}
+	}
+	for i, sourceType := range t.sourceTypes {
+		if reflect.DeepEqual(old.Sources[sourceType], conf.Sources[sourceType]) {
+			continue
+		}
+		reloaded, err := t.Sources[i].(source.Reloader).Reload(conf.Sources[sourceType])
+		if err != nil {
+			logger.Loggers().Errorf("tunnel %s reload %s source error: %v", t.Name, sourceType, err)
+			return false
+		}
+		if !reloaded {
+			return false
+		}
+	}
+	t.conf = conf
+	return true
 }
 
-func (t *TunnelModel) closeSource() {
-	t.Source.Stop()
+func (t *TunnelModel) Stop() {
+	for _, s := range t.Sources {
+		s.Stop()
+	}
+	close(t.queue)
+	if t.spool != nil {
+		if !waitTimeout(&t.spoolDone, drainTimeout) {
+			logger.Loggers().Warnf("tunnel %s spool is full, stop waiting", t.Name)
+		}
+		//未发送的事件保留在spool中，下次启动时重新发送
+		t.spool.CloseReader()
+		t.spoolDone.Wait()
+	}
+	if !waitTimeout(&t.dispatchDone, drainTimeout) {
+		logger.Loggers().Warnf("tunnel %s outputs are blocked, stop waiting", t.Name)
+	}
+	close(t.stopChan)
+	for _, p := range t.pipes {
+		if p.spool != nil {
+			p.spool.CloseReader()
+		}
+	}
+	t.dispatchDone.Wait()
+	t.feedDone.Wait()
+	for _, o := range t.Outputs {
+		o.Stop()
+	}
+	t.closeSpools()
+	//未确认的事件不会再被确认，新的tunnel从checkpoint重新读取
+	if t.ck != nil {
+		for key := range t.keys {
+			t.ck.Forget(key)
+		}
+	}
+	logger.Loggers().Infof("tunnel %s stopped", t.Name)
 }
 
-func (t *TunnelModel) startOutput() {
-	t.Output.Start()
+func (t *TunnelModel) closeSpools() {
+	for _, p := range t.pipes {
+		if p.spool != nil {
+			p.spool.Close()
+		}
+	}
+	if t.spool != nil {
+		t.spool.Close()
+	}
 }
 
-func (t *TunnelModel) closeOutput() {
-	t.Output.Stop()
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
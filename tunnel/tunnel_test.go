@@ -0,0 +1,187 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/lucky-abc/cleat/event"
+	"github.com/lucky-abc/cleat/metrics"
+	"github.com/lucky-abc/cleat/record"
+	"github.com/lucky-abc/cleat/source"
+	"github.com/spf13/cast"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const emitKey = "emit"
+
+// emitSource sends the lines given by its count key, tracked under emitKey
+// from the offset after the checkpoint.
+type emitSource struct {
+	count int
+	queue chan *event.Event
+	ck    *record.RecordPoint
+	done  sync.WaitGroup
+}
+
+func (s *emitSource) Start() {}
+
+func (s *emitSource) Process() {
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		start, _ := s.ck.GetCheckpoint(emitKey)
+		for i := start + 1; i <= uint64(s.count); i++ {
+			e := event.NewEvent("test", fmt.Sprintf("line%d", i))
+			e.Checkpoint = event.Checkpoint{Key: emitKey, Offset: i}
+			s.ck.Track(e)
+			s.queue <- e
+		}
+	}()
+}
+
+func (s *emitSource) Stop() {
+	s.done.Wait()
+}
+
+func init() {
+	source.RegisterSource("emit", func(conf interface{}) (interface{}, error) {
+		return conf, nil
+	}, func(tunnelName string, conf interface{}, queue chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) (source.Source, error) {
+		return &emitSource{count: cast.ToInt(cast.ToStringMap(conf)["count"]), queue: queue, ck: ck}, nil
+	})
+}
+
+func newTestDataPath(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cleat-tunnel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newTestCheckpoint(t *testing.T) *record.RecordPoint {
+	t.Helper()
+	ck, err := record.NewCheckpoint(filepath.Join(newTestDataPath(t), "checkpoint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ck.Close)
+	return ck
+}
+
+// closedPort returns a local tcp port nobody listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func emitTunnel(count int, outputs ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":    "t",
+		"sources": map[string]interface{}{"emit": map[string]interface{}{"count": count}},
+		"outputs": outputs,
+	}
+}
+
+func tcpOutput(port int, extra ...interface{}) interface{} {
+	conf := map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": port, "format": "raw", "writeTimeout": "200ms", "backoffMin": "10ms", "backoffMax": "50ms"}
+	for i := 0; i+1 < len(extra); i += 2 {
+		conf[extra[i].(string)] = extra[i+1]
+	}
+	return map[string]interface{}{"tcp": conf}
+}
+
+func udpOutput(port int) interface{} {
+	return map[string]interface{}{"udp": map[string]interface{}{"serverIP": "127.0.0.1", "serverPort": port, "format": "raw"}}
+}
+
+func waitCheckpoint(t *testing.T, ck *record.RecordPoint, want uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := ck.GetCheckpoint(emitKey)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("checkpoint %d, want %d", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startTunnel(t *testing.T, tunnel map[string]interface{}, dataPath string, ck *record.RecordPoint) *TunnelModel {
+	t.Helper()
+	tm, err := NewTunnel(parseTestConfig(t, []interface{}{tunnel})[0], dataPath, ck, metrics.NewMetricRegstry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.Start()
+	tm.Transfer()
+	return tm
+}
+
+func TestDeadOutputDoesNotStallTheOthers(t *testing.T) {
+	const count = 200
+	dataPath := newTestDataPath(t)
+	ck := newTestCheckpoint(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	tcpPort := closedPort(t)
+	tm := startTunnel(t, emitTunnel(count, tcpOutput(tcpPort, "name", "siem", "queueSize", 4), udpOutput(pc.LocalAddr().(*net.UDPAddr).Port)), dataPath, ck)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for i := 1; i <= count; i++ {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("udp output stalled after %d events: %v", i-1, err)
+		}
+		if got := string(buf[:n]); got != fmt.Sprintf("line%d", i) {
+			t.Fatalf("udp received %q", got)
+		}
+	}
+	//tcp的数据保存在它自己的spool中，源的checkpoint照常前进
+	waitCheckpoint(t, ck, count)
+	tm.Stop()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	tm = startTunnel(t, emitTunnel(count, tcpOutput(ln.Addr().(*net.TCPAddr).Port, "name", "siem", "queueSize", 4), udpOutput(pc.LocalAddr().(*net.UDPAddr).Port)), dataPath, ck)
+	defer tm.Stop()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for i := 1; i <= count; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("tcp received %d spooled events: %v", i-1, err)
+		}
+		if got := strings.TrimSuffix(line, "\n"); got != fmt.Sprintf("line%d", i) {
+			t.Fatalf("tcp received %q, want line%d", got, i)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/beevik/etree"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
 	"github.com/lucky-abc/cleat/record"
@@ -35,7 +36,7 @@ type WindowsLog struct {
 	eventHandle   wineventapi.EvtHandle
 	outputBuf     *bytes.Buffer
 	renderBuf     []byte
-	queue         chan string
+	queue         chan *event.Event
 	cancelContext context.Context
 	cancelFun     func()
 	waitGroup     sync.WaitGroup
@@ -45,7 +46,7 @@ type WindowsLog struct {
 	recorCounter  *metrics.Counter
 }
 
-func NewWindowsLog(logName string, queue chan string, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) *WindowsLog {
+func NewWindowsLog(tunnelName string, logName string, queue chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) *WindowsLog {
 	l := &WindowsLog{
 		LogName:   logName,
 		outputBuf: bytes.NewBuffer(make([]byte, 1<<14)),
@@ -54,13 +55,14 @@ func NewWindowsLog(logName string, queue chan string, ck *record.RecordPoint, me
 		ck:        ck,
 		runFlag:   0,
 	}
-	metricMeter := metrics.NewMeter("windowevent[" + logName + "]-read-rate")
+	metricRegistry.RegisterLabels(tunnelName+"-windowevent["+logName+"]", "windowevent", "channel", logName)
+	metricMeter := metrics.NewMeter(tunnelName + "-windowevent[" + logName + "]-read-rate")
 	metricRegistry.RegisterMetric(metricMeter)
 	context, cancelf := context.WithCancel(context.Background())
 	l.cancelContext = context
 	l.cancelFun = cancelf
 	l.metricMeter = metricMeter
-	l.recorCounter = metricRegistry.GetCounter("windowevent-record-total")
+	l.recorCounter = metricRegistry.GetCounter(tunnelName + "-windowevent-record-total")
 	return l
 }
 
@@ -166,21 +168,30 @@ func (log *WindowsLog) eventlogRender(eventHandles []uintptr) error {
 		}
 		UTF16ToUTF8Bytes(log.renderBuf[:bufferUsed], log.outputBuf)
 		//logger.Loggers().Debugf("windows event xml:%v", string(log.outputBuf.Bytes()))
-		xmlEvent, eventRecordID, err := log.rebuildXml(log.outputBuf.Bytes())
+		xmlEvent, eventRecordID, level, err := log.rebuildXml(log.outputBuf.Bytes())
 		if err != nil {
 			logger.Loggers().Errorf("windows event rebuild error:%v", err)
 			return err
 		}
+		ckKey := fmt.Sprintf(checkpointTemplate, log.LogName)
+		e := event.NewEvent(log.LogName, xmlEvent)
+		e.SetField("channel", log.LogName)
+		e.SetField("recordId", eventRecordID)
+		if level != "" {
+			e.SetField("level", level)
+		}
+		e.Checkpoint = event.Checkpoint{Key: ckKey, Offset: eventRecordID}
+		log.ck.Track(e)
 	lfor:
 		for {
 			select {
 			case <-log.cancelContext.Done():
+				log.ck.Untrack(e)
 				return errors.New("window event close")
-			case log.queue <- xmlEvent:
+			case log.queue <- e:
 				log.RecordNumber = eventRecordID
 				log.metricMeter.Update(1)
 				log.recorCounter.Incr(1)
-				log.ck.SetCheckpoint(fmt.Sprintf(checkpointTemplate, log.LogName), eventRecordID)
 				break lfor
 			}
 		}
@@ -188,12 +199,12 @@ func (log *WindowsLog) eventlogRender(eventHandles []uintptr) error {
 	return nil
 }
 
-func (log *WindowsLog) rebuildXml(xmlbytes []byte) (string, uint64, error) {
+func (log *WindowsLog) rebuildXml(xmlbytes []byte) (string, uint64, string, error) {
 	doc := etree.NewDocument()
 	err := doc.ReadFromBytes(xmlbytes)
 	if err != nil {
 		logger.Loggers().Errorf("window event rebuild data error:%v", err)
-		return "", 0, err
+		return "", 0, "", err
 	}
 
 	securityEle := doc.FindElement("//System/Security")
@@ -208,16 +219,20 @@ func (log *WindowsLog) rebuildXml(xmlbytes []byte) (string, uint64, error) {
 	result, err := doc.WriteToString()
 	if err != nil {
 		logger.Loggers().Errorf("window event rebuild data to string error:%v", err)
-		return "", 0, err
+		return "", 0, "", err
 	}
 	eventIDStr := doc.FindElement("//System/EventRecordID").Text()
 	eventID, err := strconv.ParseUint(eventIDStr, 10, 64)
 	if err != nil {
 		logger.Loggers().Errorf("window event parse eventID error:%v", err)
-		return "", 0, err
+		return "", 0, "", err
+	}
+	var level string
+	if levelEle := doc.FindElement("//System/Level"); levelEle != nil {
+		level = levelEle.Text()
 	}
 	//logger.Loggers().Debug("***********:", result)
-	return result, eventID, nil
+	return result, eventID, level, nil
 
 }
 
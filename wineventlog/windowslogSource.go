@@ -2,29 +2,64 @@ package wineventlog
 
 import (
 	"github.com/lucky-abc/cleat/config"
+	"github.com/lucky-abc/cleat/event"
 	"github.com/lucky-abc/cleat/logger"
 	"github.com/lucky-abc/cleat/metrics"
 	"github.com/lucky-abc/cleat/record"
+	"github.com/lucky-abc/cleat/source"
+	"github.com/lucky-abc/cleat/wineventlog/wineventapi"
+	"github.com/pkg/errors"
 )
 
+// WindowsConfig is the windows source section, EventName lists the event
+// log channels to read.
+type WindowsConfig struct {
+	EventName []string `mapstructure:"eventname"`
+}
+
 type WinLogSource struct {
-	logChan     chan string
+	logChan     chan *event.Event
 	windowsLogs []*WindowsLog
 }
 
-func NewWinLogSource(c chan string, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) *WinLogSource {
-	eventNames := config.Config().GetStringSlice("windows.event.eventname")
+func init() {
+	source.RegisterSource("windows", parseWindowsConfig, func(tunnelName string, conf interface{}, queue chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) (source.Source, error) {
+		available, _ := wineventapi.IsAvailable()
+		if !available {
+			return nil, errors.New("Windows API is not supported on the current platform")
+		}
+		s := NewWinLogSource(tunnelName, conf.(*WindowsConfig).EventName, queue, ck, metricRegistry)
+		if s == nil {
+			return nil, errors.New("no window event channel")
+		}
+		return s, nil
+	})
+}
+
+func parseWindowsConfig(conf interface{}) (interface{}, error) {
+	windowsConfig := &WindowsConfig{}
+	if err := config.Decode(conf, windowsConfig); err != nil {
+		return nil, err
+	}
+	errs := &config.Errors{}
+	if len(windowsConfig.EventName) == 0 {
+		errs.Add("eventname", "no window event channel")
+	}
+	return windowsConfig, errs.Err()
+}
+
+func NewWinLogSource(tunnelName string, eventNames []string, c chan *event.Event, ck *record.RecordPoint, metricRegistry *metrics.MetricRegistry) *WinLogSource {
 	logger.Loggers().Infof("window event channel: %v", eventNames)
 	if len(eventNames) == 0 {
 		logger.Loggers().Errorf("no window event channel")
 		return nil
 	}
-	recordNumMetric := metrics.NewCounter("windowevent-record-total")
+	recordNumMetric := metrics.NewCounter(tunnelName + "-windowevent-record-total")
 	metricRegistry.RegisterMetric(recordNumMetric)
 
 	windowLogs := make([]*WindowsLog, len(eventNames))
 	for i, eventname := range eventNames {
-		l := NewWindowsLog(eventname, c, ck, metricRegistry)
+		l := NewWindowsLog(tunnelName, eventname, c, ck, metricRegistry)
 		windowLogs[i] = l
 	}
 	s := &WinLogSource{
@@ -51,5 +86,4 @@ func (s *WinLogSource) Stop() {
 	for _, log := range s.windowsLogs {
 		log.Close()
 	}
-	close(s.logChan)
 }